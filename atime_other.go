@@ -0,0 +1,19 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux && !dragonfly && !openbsd && !solaris && !darwin && !freebsd && !netbsd
+// +build !linux,!dragonfly,!openbsd,!solaris,!darwin,!freebsd,!netbsd
+
+package scplib
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime return the access time of info. The access time is not
+// available on this platform, so modification time is used instead.
+func fileAtime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
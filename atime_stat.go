@@ -0,0 +1,22 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build linux || dragonfly || openbsd || solaris
+// +build linux dragonfly openbsd solaris
+
+package scplib
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAtime return the access time of info.
+func fileAtime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+// HashAlgorithm is the hash algorithm of checksum verification.
+type HashAlgorithm struct {
+	Name string
+
+	// New return the hash to compute the local checksums.
+	New func() hash.Hash
+
+	// Commands are the remote commands, that print the checksums of the
+	// files given as arguments in the format of sha256sum. They are tried
+	// in order, while the command is not found.
+	Commands [][]string
+}
+
+// SHA256 is the SHA-256 checksum, computed by sha256sum or shasum on the
+// remote.
+var SHA256 = &HashAlgorithm{
+	Name:     "SHA-256",
+	New:      sha256.New,
+	Commands: [][]string{{"sha256sum"}, {"shasum", "-a", "256"}},
+}
+
+// sumBatch is the number of files, passed to a remote checksum command.
+const sumBatch = 100
+
+// ChecksumError is the mismatch of the local and remote checksums of a
+// transferred file.
+type ChecksumError struct {
+	Path string // remote path
+
+	// Local and Remote are the checksums in hex. Remote is empty if the
+	// remote command did not print it.
+	Local  string
+	Remote string
+}
+
+func (e *ChecksumError) Error() string {
+	if e.Remote == "" {
+		return "scplib: no remote checksum of " + e.Path
+	}
+	return fmt.Sprintf("scplib: checksum mismatch of %s: local %s, remote %s", e.Path, e.Local, e.Remote)
+}
+
+// verifier collect the checksums of files transferred successfully.
+type verifier struct {
+	alg   *HashAlgorithm
+	files []verifiedFile
+}
+
+type verifiedFile struct {
+	rel string // relative path in the transfer
+	sum []byte
+}
+
+// verifier return the verifier of a transfer, or nil if not required.
+func (s *SCPClient) verifier() *verifier {
+	if s.Verify == nil || s.DryRun {
+		return nil
+	}
+	return &verifier{alg: s.Verify}
+}
+
+// hash return a new hash of a file. It is nil if v is nil.
+func (v *verifier) hash() hash.Hash {
+	if v == nil {
+		return nil
+	}
+	return v.alg.New()
+}
+
+// add add the checksum of the file at rel.
+func (v *verifier) add(rel string, h hash.Hash) {
+	if v != nil && h != nil {
+		v.files = append(v.files, verifiedFile{rel: rel, sum: h.Sum(nil)})
+	}
+}
+
+// verifyHandler compute the checksums of the files passed to sinkHandler.
+type verifyHandler struct {
+	sinkHandler
+	v *verifier
+
+	dirs []string  // stack of relative paths of directories
+	rel  string    // relative path of the current file
+	h    hash.Hash // hash of the current file
+}
+
+func (w *verifyHandler) handle(hdr *Header, body io.Reader) error {
+	rel := hdr.Name
+	if len(w.dirs) > 0 {
+		rel = path.Join(w.dirs[len(w.dirs)-1], hdr.Name)
+	}
+
+	switch hdr.Type {
+	case TypeFile:
+		w.rel, w.h = rel, w.v.hash()
+		body = io.TeeReader(body, w.h)
+	case TypeDir:
+		// the rejected directory is not entered
+		if err := w.sinkHandler.handle(hdr, body); err != nil {
+			return err
+		}
+		w.dirs = append(w.dirs, rel)
+		return nil
+	case TypeEnd:
+		w.dirs = w.dirs[:len(w.dirs)-1]
+	}
+	return w.sinkHandler.handle(hdr, body)
+}
+
+func (w *verifyHandler) finish(ferr error) error {
+	err := finishFile(w.sinkHandler, ferr)
+	if ferr == nil && err == nil {
+		w.v.add(w.rel, w.h)
+	}
+	w.rel, w.h = "", nil
+	return err
+}
+
+// verifyRemote compare the checksums in v with the remote files. remotePath
+// return the remote path of the relative path in the transfer.
+func (s *SCPClient) verifyRemote(ctx context.Context, v *verifier, remotePath func(rel string) string) error {
+	if v == nil || len(v.files) == 0 {
+		return nil
+	}
+
+	paths := make([]string, len(v.files))
+	for i, f := range v.files {
+		paths[i] = remotePath(f.rel)
+	}
+
+	sums, err := s.remoteSums(ctx, v.alg, paths)
+	if err != nil {
+		return err
+	}
+
+	var errs errorList
+	for i, f := range v.files {
+		local := hex.EncodeToString(f.sum)
+		if sums[i] != local {
+			errs.add(&ChecksumError{Path: paths[i], Local: local, Remote: sums[i]})
+		}
+	}
+	return errs.err()
+}
+
+// remoteSums return the checksums of the remote paths in hex, by the
+// commands of alg. The checksums of the files not printed are empty.
+func (s *SCPClient) remoteSums(ctx context.Context, alg *HashAlgorithm, paths []string) ([]string, error) {
+	sums := make([]string, 0, len(paths))
+	commands := alg.Commands
+
+	for start := 0; start < len(paths); start += sumBatch {
+		end := start + sumBatch
+		if end > len(paths) {
+			end = len(paths)
+		}
+		batch := paths[start:end]
+
+		for {
+			if len(commands) == 0 {
+				return nil, fmt.Errorf("scplib: no remote command of %s", alg.Name)
+			}
+
+			args := make([]string, len(commands[0]))
+			for i, arg := range commands[0] {
+				args[i] = shellQuote(arg)
+			}
+			cmd, err := s.remoteCommand(args, batch)
+			if err != nil {
+				return nil, err
+			}
+
+			// exit status 1 for the missing files, and 127 for the
+			// missing command
+			out, err := s.output(ctx, cmd)
+			if status := exitStatus(err); status == 127 {
+				commands = commands[1:]
+				continue
+			} else if err != nil && status != 1 {
+				return nil, err
+			}
+
+			sums = append(sums, parseSums(out, batch)...)
+			break
+		}
+	}
+	return sums, nil
+}
+
+// parseSums parse the output of sha256sum for paths, and return the
+// checksums in the order of paths. The lines are in the order of the
+// arguments, and the missing files are skipped. The path beginning with
+// `~` is printed as expanded by the shell.
+func parseSums(out []byte, paths []string) []string {
+	sums := make([]string, len(paths))
+	next := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// the name with backslash or newline is escaped
+		escaped := strings.HasPrefix(line, "\\")
+		if escaped {
+			line = line[1:]
+		}
+
+		i := strings.Index(line, " ")
+		if i < 0 || i+2 > len(line) {
+			continue
+		}
+		sum, name := strings.ToLower(line[:i]), line[i+2:]
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+
+		for j := next; j < len(paths); j++ {
+			if sameRemotePath(paths[j], name) {
+				sums[j] = sum
+				next = j + 1
+				break
+			}
+		}
+	}
+	return sums
+}
+
+// sameRemotePath report whether the remote path arg is printed as name.
+func sameRemotePath(arg, name string) bool {
+	if arg == name {
+		return true
+	}
+	if strings.HasPrefix(arg, "~") {
+		if i := strings.Index(arg, "/"); i >= 0 {
+			return strings.HasSuffix(name, arg[i:])
+		}
+	}
+	return false
+}
+
+// remoteIsDir report whether the remote path p is a directory.
+func (s *SCPClient) remoteIsDir(ctx context.Context, p string) (bool, error) {
+	cmd, err := s.remoteCommand([]string{"test", "-d", quotePath(p)}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.output(ctx, cmd)
+	if err != nil && exitStatus(err) != 1 {
+		return false, err
+	}
+	return err == nil, nil
+}
+
+// sourcePath return the function to get the remote path of the relative
+// path, received from fromPaths. The top of the relative path is the base
+// name of the matched path in fromPaths.
+func sourcePath(fromPaths []string) func(rel string) string {
+	return func(rel string) string {
+		top, rest := rel, ""
+		if i := strings.Index(rel, "/"); i >= 0 {
+			top, rest = rel[:i], rel[i:]
+		}
+
+		for _, p := range fromPaths {
+			p = path.Clean(p)
+			if matched, _ := path.Match(path.Base(p), top); matched || path.Base(p) == top {
+				return path.Join(path.Dir(p), top) + rest
+			}
+		}
+		return rel
+	}
+}
+
+// sinkPath return the function to get the remote path of the relative path,
+// sent to toPath. If toPath is a directory before the transfer, the entries
+// are put into it. Otherwise the top entry is created as toPath.
+func (s *SCPClient) sinkPath(ctx context.Context, toPath string) (func(rel string) string, error) {
+	isDir, err := s.remoteIsDir(ctx, toPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(rel string) string {
+		if isDir {
+			return path.Join(toPath, rel)
+		}
+		if i := strings.Index(rel, "/"); i >= 0 {
+			return path.Join(toPath, rel[i+1:])
+		}
+		return toPath
+	}, nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifiedSums return the relative paths and checksums in v.
+func verifiedSums(v *verifier) map[string]string {
+	sums := map[string]string{}
+	for _, f := range v.files {
+		sums[f.rel] = hex.EncodeToString(f.sum)
+	}
+	return sums
+}
+
+func TestParseSums(t *testing.T) {
+	a, b, c := sha256Hex("a"), sha256Hex("b"), sha256Hex("c")
+	out := a + "  /r/a\n" +
+		// /r/missing is not printed
+		strings.ToUpper(b) + " */r/b b\n" +
+		"\\" + c + "  /r/c\\nd\\\\e\n" +
+		a + "  /home/user/x\n"
+
+	paths := []string{"/r/a", "/r/missing", "/r/b b", "/r/c\nd\\e", "~/x"}
+	got := parseSums([]byte(out), paths)
+	want := []string{a, "", b, c, a}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sums = %q, want %q", got, want)
+	}
+}
+
+func TestSourcePath(t *testing.T) {
+	remotePath := sourcePath([]string{"/r/dir/", "~/file", "/r/logs/*.log"})
+
+	tests := map[string]string{
+		"dir":           "/r/dir",
+		"dir/sub/a":     "/r/dir/sub/a",
+		"file":          "~/file",
+		"app.log":       "/r/logs/app.log",
+		"unknown/a.txt": "unknown/a.txt",
+	}
+	for rel, want := range tests {
+		if got := remotePath(rel); got != want {
+			t.Errorf("remote path of %q = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+func TestVerifySink(t *testing.T) {
+	dir := makeTree(t, nil)
+	defer os.RemoveAll(dir)
+
+	f := &fakeSource{msgs: []string{
+		"D0755 0 top\n",
+		fileMsg("a", "aaa"),
+		"C0644 3 b\nbbb\x01scp: b: read error\n",
+		"D0755 0 sub\n",
+		fileMsg("c", "ccc"),
+		"E\n",
+		"E\n",
+	}}
+
+	v := &verifier{alg: SHA256}
+	h := &verifyHandler{sinkHandler: &fileWriter{path: dir}, v: v}
+	if _, ok := runFakeSource(f, h).(*RemoteError); !ok {
+		t.Fatal("warning of b is not returned")
+	}
+
+	// b is not verified, because the source failed to send it
+	want := map[string]string{"top/a": sha256Hex("aaa"), "top/sub/c": sha256Hex("ccc")}
+	if got := verifiedSums(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("sums = %q, want %q", got, want)
+	}
+}
+
+func TestVerifySinkFilter(t *testing.T) {
+	dir := makeTree(t, nil)
+	defer os.RemoveAll(dir)
+
+	f := &fakeSource{msgs: []string{
+		"D0755 0 top\n",
+		fileMsg("a", "aaa"),
+		fileMsg("x.log", "xxx"),
+		fileMsg("c", "ccc"),
+		"E\n",
+	}}
+
+	// the excluded file is not verified, and a is added once
+	v := &verifier{alg: SHA256}
+	h := &verifyHandler{sinkHandler: &fileWriter{path: dir}, v: v}
+	err := runFakeSourceWith(f, h, func(k *sink) {
+		k.filter = &Filter{}
+		k.filter.Exclude("*.log")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.files) != 2 || v.files[0].rel != "top/a" || v.files[1].rel != "top/c" {
+		t.Errorf("files = %+v, want top/a and top/c", v.files)
+	}
+}
+
+func TestVerifySource(t *testing.T) {
+	dir := makeTree(t, map[string]string{"top/a": "aaa", "top/sub/b": "bb"})
+	defer os.RemoveAll(dir)
+
+	v := &verifier{alg: SHA256}
+	f := &fakeSink{reject: map[string]byte{"b": respWarning}}
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		c.verify = v
+		return (&pusher{c: c}).push(filepath.Join(dir, "top"), "top")
+	})
+	if _, ok := err.(*RemoteError); !ok {
+		t.Fatalf("err = %v, want *RemoteError", err)
+	}
+
+	want := map[string]string{"top/a": sha256Hex("aaa")}
+	if got := verifiedSums(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("sums = %q, want %q", got, want)
+	}
+}
+
+func TestChecksumError(t *testing.T) {
+	err := &ChecksumError{Path: "/r/a", Local: "00", Remote: "ff"}
+	if got, want := err.Error(), "scplib: checksum mismatch of /r/a: local 00, remote ff"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+
+	err.Remote = ""
+	if got, want := err.Error(), "scplib: no remote checksum of /r/a"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
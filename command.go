@@ -0,0 +1,105 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultSCPPath is the path of remote scp, used when SCPClient.SCPPath is empty.
+const DefaultSCPPath = "/usr/bin/scp"
+
+// sourceCommand return the command of remote scp, that send fromPaths.
+func (s *SCPClient) sourceCommand(fromPaths []string) (string, error) {
+	mode := "-rf"
+	if s.Permission == true {
+		mode = "-prf"
+	}
+	return s.command(mode, fromPaths)
+}
+
+// sinkCommand return the command of remote scp, that receive to toPath.
+// If targetDir, toPath must be a directory (-d), as OpenSSH scp require for
+// many sources.
+func (s *SCPClient) sinkCommand(toPath string, targetDir bool) (string, error) {
+	mode := "-tr"
+	if targetDir {
+		mode = "-dtr"
+	}
+	if s.Permission == true {
+		mode = "-p" + mode[1:]
+	}
+	return s.command(mode, []string{toPath})
+}
+
+// command build the remote command line:
+//
+//	[ENV=value...] [wrapper... [env ENV=value...]] scp mode [flags...] -- paths...
+//
+// All words are quoted for the remote shell, and `--` keeps paths beginning
+// with `-` from being parsed as options.
+func (s *SCPClient) command(mode string, paths []string) (string, error) {
+	scpPath := s.SCPPath
+	if scpPath == "" {
+		scpPath = DefaultSCPPath
+	}
+
+	args := []string{quotePath(scpPath), mode}
+	for _, f := range s.Flags {
+		args = append(args, shellQuote(f))
+	}
+	return s.remoteCommand(args, paths)
+}
+
+// remoteCommand build the remote command line of args (already quoted) and
+// paths, with Env and Wrapper in the same way as command. `--` is omitted if
+// there are no paths.
+func (s *SCPClient) remoteCommand(args []string, paths []string) (string, error) {
+	env := make([]string, 0, len(s.Env))
+	for _, kv := range s.Env {
+		i := strings.Index(kv, "=")
+		if i < 0 || !isEnvName(kv[:i]) {
+			return "", fmt.Errorf("scplib: invalid environment variable %q", kv)
+		}
+		env = append(env, kv[:i]+"="+shellQuote(kv[i+1:]))
+	}
+
+	words := []string{}
+	if len(s.Wrapper) > 0 {
+		for _, w := range s.Wrapper {
+			words = append(words, shellQuote(w))
+		}
+
+		// the prefix of wrapper is not passed to the command, e.g. by sudo
+		if len(env) > 0 {
+			words = append(words, "env")
+		}
+	}
+	words = append(words, env...)
+	words = append(words, args...)
+
+	if len(paths) > 0 {
+		words = append(words, "--", quotePaths(paths))
+	}
+	return strings.Join(words, " "), nil
+}
+
+// isEnvName report whether name is valid as a shell variable name.
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		client SCPClient
+		source bool
+		dir    bool // target of sink must be a directory
+		paths  []string
+		want   string
+	}{
+		{
+			name:  "default sink",
+			paths: []string{"/tmp/dir"},
+			want:  "/usr/bin/scp -tr -- /tmp/dir",
+		},
+		{
+			name:   "permission",
+			client: SCPClient{Permission: true},
+			paths:  []string{"a'; rm -rf ~; '"},
+			want:   `/usr/bin/scp -ptr -- 'a'\''; rm -rf ~; '\'''`,
+		},
+		{
+			name:   "sink to directory",
+			client: SCPClient{Permission: true},
+			dir:    true,
+			paths:  []string{"/tmp/dir"},
+			want:   "/usr/bin/scp -pdtr -- /tmp/dir",
+		},
+		{
+			name:   "source",
+			source: true,
+			paths:  []string{"-x", "a b", "~/c"},
+			want:   "/usr/bin/scp -rf -- -x 'a b' ~/c",
+		},
+		{
+			name:   "source with permission",
+			client: SCPClient{Permission: true},
+			source: true,
+			paths:  []string{"a"},
+			want:   "/usr/bin/scp -prf -- a",
+		},
+		{
+			name:   "scp in PATH with flags",
+			client: SCPClient{SCPPath: "scp", Flags: []string{"-v", "-o weird"}},
+			source: true,
+			paths:  []string{"a"},
+			want:   "scp -rf -v '-o weird' -- a",
+		},
+		{
+			name:   "env",
+			client: SCPClient{SCPPath: "/usr/local/bin/scp", Env: []string{"LC_ALL=C", "X=a b"}},
+			paths:  []string{"a"},
+			want:   "LC_ALL=C X='a b' /usr/local/bin/scp -tr -- a",
+		},
+		{
+			name:   "wrapper",
+			client: SCPClient{Wrapper: []string{"sudo", "-n"}},
+			paths:  []string{"a"},
+			want:   "sudo -n /usr/bin/scp -tr -- a",
+		},
+		{
+			name:   "wrapper with env",
+			client: SCPClient{Wrapper: []string{"sudo", "-n"}, Env: []string{"TMPDIR=/var/tmp"}},
+			source: true,
+			paths:  []string{"a"},
+			want:   "sudo -n env TMPDIR=/var/tmp /usr/bin/scp -rf -- a",
+		},
+	}
+
+	for _, test := range tests {
+		var got string
+		var err error
+		if test.source {
+			got, err = test.client.sourceCommand(test.paths)
+		} else {
+			got, err = test.client.sinkCommand(test.paths[0], test.dir)
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: command = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCommandInvalidEnv(t *testing.T) {
+	for _, env := range []string{"NOVALUE", "1X=a", "A B=c", "=x", "X;rm=y"} {
+		s := &SCPClient{Env: []string{env}}
+		if _, err := s.sourceCommand([]string{"a"}); err == nil {
+			t.Errorf("env %q: expected error", env)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"io"
+)
+
+// CopyRemote copy srcPaths on the remote of src to dstPath on the remote of
+// dst (remote to remote). The data from `scp -f` of src is sent to `scp -t`
+// of dst through a pipe, without local files, and each side is answered by
+// this process. The options of each client are used for its side, e.g.
+// Filter of src and ErrorPolicy of dst.
+//
+// The errors of both sides are returned (as *MultiError, if more than one).
+// RemoteError.Path is set only for the files rejected by dst.
+//
+// example:
+//
+//	err := scplib.CopyRemote(src, []string{"/From/Remote/Path"}, dst, "/To/Remote/Path")
+func CopyRemote(src *SCPClient, srcPaths []string, dst *SCPClient, dstPath string) error {
+	return CopyRemoteContext(context.Background(), src, srcPaths, dst, dstPath)
+}
+
+// CopyRemoteContext is CopyRemote with ctx. If ctx is done before the end,
+// the transfers are stopped, and ctx.Err() is returned.
+func CopyRemoteContext(ctx context.Context, src *SCPClient, srcPaths []string, dst *SCPClient, dstPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	srcCmd, err := src.sourceCommand(srcPaths)
+	if err != nil {
+		return err
+	}
+	dstCmd, err := dst.sinkCommand(dstPath, len(srcPaths) > 1)
+	if err != nil {
+		return err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the data ends at the end of src, even if src failed
+	pr, pw := io.Pipe()
+	srcErr := make(chan error, 1)
+	go func() {
+		err := src.runSink(ctx, srcCmd, &rawWriter{w: pw})
+		pw.Close()
+		srcErr <- err
+	}()
+
+	dstErr := dst.runSource(ctx, dstCmd, func(c *source) error {
+		return c.sendData(pr)
+	})
+
+	// stop src, if dst stopped before the end
+	pr.CloseWithError(dstErr)
+	if dstErr != nil {
+		cancel()
+	}
+	serr := <-srcErr
+
+	if err := parent.Err(); err != nil {
+		return err
+	}
+
+	// the errors caused by the other side are not returned
+	var errs errorList
+	if serr != nil && !(dstErr != nil && serr == context.Canceled) {
+		errs.add(serr)
+	}
+	if dstErr != nil && !(serr != nil && dstErr == io.ErrUnexpectedEOF) {
+		errs.add(dstErr)
+	}
+	return errs.err()
+}
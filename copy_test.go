@@ -0,0 +1,116 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// runRelay connect fakeSource and fakeSink through a pipe, in the same way
+// as CopyRemote, and return the errors of both sides.
+func runRelay(src *fakeSource, dst *fakeSink, policy ErrorPolicy) (serr, derr error) {
+	pr, pw := io.Pipe()
+	srcErr := make(chan error, 1)
+	go func() {
+		err := runFakeSource(src, &rawWriter{w: pw})
+		pw.Close()
+		srcErr <- err
+	}()
+
+	derr = runFakeSink(dst, policy, func(c *source) error {
+		return c.sendData(pr)
+	})
+	pr.CloseWithError(derr)
+	return <-srcErr, derr
+}
+
+func TestRawWriterBrokenFile(t *testing.T) {
+	f := &fakeSource{msgs: []string{
+		"C0644 3 a\naaa\x01scp: a: read error\n",
+		fileMsg("b", "b"),
+	}}
+
+	got := new(bytes.Buffer)
+	err := runFakeSource(f, &rawWriter{w: got})
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Message != "scp: a: read error" {
+		t.Fatalf("err = %v, want warning *RemoteError", err)
+	}
+
+	// the status is written after the data
+	want := "C0644 3 a\naaa\x01scp: a: read error\n" + fileMsg("b", "b")
+	if got.String() != want {
+		t.Errorf("data = %q, want %q", got.String(), want)
+	}
+}
+
+func TestCopyRelay(t *testing.T) {
+	src := &fakeSource{msgs: []string{
+		"D0755 0 top\n",
+		fileMsg("a", "aaa"),
+		"C0644 2 broken\nbb\x01scp: broken: read error\n",
+		"D0755 0 skip\n",
+		fileMsg("b", "bbb"),
+		"E\n",
+		fileMsg("c", "c"),
+		"E\n",
+	}}
+	dst := &fakeSink{reject: map[string]byte{"skip": respWarning}}
+
+	serr, derr := runRelay(src, dst, ContinueOnError)
+	if src.err != nil || dst.err != nil {
+		t.Fatalf("fake errors: %v, %v", src.err, dst.err)
+	}
+	if rerr, ok := serr.(*RemoteError); !ok || rerr.Message != "scp: broken: read error" {
+		t.Errorf("src err = %v, want warning *RemoteError", serr)
+	}
+
+	merr, ok := derr.(*MultiError)
+	if !ok || len(merr.Errors) != 2 {
+		t.Fatalf("dst err = %v, want 2 errors", derr)
+	}
+	if rerr, ok := merr.Errors[1].(*RemoteError); !ok || rerr.Path != "top/skip" {
+		t.Errorf("dst err = %v, want *RemoteError of top/skip", merr.Errors[1])
+	}
+
+	wantLines := []string{
+		"D0755 0 top",
+		"C0644 3 a",
+		"C0644 2 broken",
+		"error: scplib: remote warning: scp: broken: read error",
+		"D0755 0 skip",
+		"C0644 1 c",
+		"E",
+	}
+	if !reflect.DeepEqual(dst.lines, wantLines) {
+		t.Errorf("lines = %q, want %q", dst.lines, wantLines)
+	}
+	if want := map[string]string{"a": "aaa", "c": "c"}; !reflect.DeepEqual(dst.data, want) {
+		t.Errorf("data = %q, want %q", dst.data, want)
+	}
+
+	// all acks of src are answered
+	if src.acks != 13 {
+		t.Errorf("src received %d acks, want 13", src.acks)
+	}
+}
+
+func TestCopyRelayFatal(t *testing.T) {
+	src := &fakeSource{msgs: []string{
+		fileMsg("a", "aaa"),
+		fileMsg("b", "bbb"),
+	}}
+	dst := &fakeSink{reject: map[string]byte{"a": respFatal}}
+
+	serr, derr := runRelay(src, dst, AbortOnError)
+	if rerr, ok := derr.(*RemoteError); !ok || rerr.Severity != SeverityFatal {
+		t.Errorf("dst err = %v, want fatal *RemoteError", derr)
+	}
+	if serr == nil {
+		t.Error("src is not stopped")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrIdleTimeout is returned when no bytes are sent or received for the
+// IdleTimeout of SCPClient.
+var ErrIdleTimeout = errors.New("scplib: transfer idle timeout")
+
+// ErrNoConnection is returned when a feature needs a new session for
+// remote commands, but SCPClient has only Session.
+var ErrNoConnection = errors.New("scplib: remote commands require Connection")
+
+// Severity is the severity of an error message sent by the remote scp.
+type Severity int
+
+const (
+	// SeverityWarning is a message sent with 0x01. The remote continues the
+	// transfer after it.
+	SeverityWarning Severity = 1
+
+	// SeverityFatal is a message sent with 0x02. The remote stops the
+	// transfer after it.
+	SeverityFatal Severity = 2
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityFatal:
+		return "fatal"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// RemoteError is a warning or fatal message sent by the remote scp.
+type RemoteError struct {
+	Severity Severity
+	Message  string
+
+	// Path is the relative path of the file or directory rejected by the
+	// remote. It is set only for uploads.
+	Path string
+}
+
+func (e *RemoteError) Error() string {
+	return "scplib: remote " + e.Severity.String() + ": " + e.Message
+}
+
+// LocalIOError is an error of the local file system, while a transfer.
+type LocalIOError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *LocalIOError) Error() string {
+	return "scplib: " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *LocalIOError) Unwrap() error {
+	return e.Err
+}
+
+// localError wrap err as LocalIOError. The path in *os.PathError is not
+// repeated in the message.
+func localError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if perr, ok := err.(*os.PathError); ok {
+		err = perr.Err
+	}
+	return &LocalIOError{Op: op, Path: path, Err: err}
+}
+
+// ProtocolError is a malformed or unexpected scp protocol message.
+type ProtocolError struct {
+	// Line is the offending protocol line, if any.
+	Line string
+	Msg  string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Line == "" {
+		return "scplib: protocol error: " + e.Msg
+	}
+	return fmt.Sprintf("scplib: protocol error: %s: %q", e.Msg, e.Line)
+}
+
+// MultiError is the list of errors of a transfer, that continued after
+// errors of some files.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("scplib: %d errors: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap return the errors, for errors.Is and errors.As.
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is report whether any error in e matches target.
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As find the first error in e that matches target.
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorList collect the errors of files, while a transfer continues.
+type errorList []error
+
+func (l *errorList) add(err error) {
+	*l = append(*l, err)
+}
+
+// err return nil, the only error, or MultiError of all errors.
+func (l errorList) err() error {
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+	return &MultiError{Errors: append([]error(nil), l...)}
+}
+
+// with return the errors of l and then err, in the same way as err. The
+// errors skipped before the transfer failed with err are kept.
+func (l errorList) with(err error) error {
+	if err != nil {
+		l = append(l[:len(l):len(l)], err)
+	}
+	return l.err()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalIOErrorUnwrap(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "aaa"})
+	defer os.RemoveAll(dir)
+
+	f := &fakeSink{}
+	missing := filepath.Join(dir, "missing")
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		p := &pusher{c: c}
+		if err := p.pushFileData(missing, "missing"); err != nil {
+			return err
+		}
+		return p.pushFileData(filepath.Join(dir, "a"), "a")
+	})
+
+	var lerr *LocalIOError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("err = %v, want *LocalIOError", err)
+	}
+	if lerr.Op != "open" || lerr.Path != missing {
+		t.Errorf("unexpected local error %+v", lerr)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("errors.Is(%v, os.ErrNotExist) = false", err)
+	}
+	if f.data["a"] != "aaa" {
+		t.Errorf("file after missing file was not sent")
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var errs errorList
+	if errs.err() != nil {
+		t.Fatal("empty errorList returns error")
+	}
+
+	rerr := &RemoteError{Severity: SeverityWarning, Message: "scp: a: Permission denied"}
+	errs.add(rerr)
+	if errs.err() != rerr {
+		t.Fatalf("single error is not returned as is")
+	}
+
+	errs.add(&LocalIOError{Op: "open", Path: "b", Err: os.ErrPermission})
+	errs.add(&ProtocolError{Line: "X", Msg: "unknown protocol line"})
+	err := errs.err()
+
+	var merr *MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) != 3 {
+		t.Fatalf("err = %v, want *MultiError of 3 errors", err)
+	}
+
+	var gotRemote *RemoteError
+	if !errors.As(err, &gotRemote) || gotRemote != rerr {
+		t.Errorf("errors.As(*RemoteError) failed")
+	}
+
+	var gotProto *ProtocolError
+	if !errors.As(err, &gotProto) || gotProto.Line != "X" {
+		t.Errorf("errors.As(*ProtocolError) failed")
+	}
+
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("errors.Is(os.ErrPermission) failed")
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter select the files and directories of recursive transfers, by the
+// relative path in the transfer (e.g. "dir/sub/file", same as Path of
+// ProgressEvent). The zero value and nil select everything.
+//
+// The exclude rules (Exclude, ExcludeRegexp and AddGitignore) are checked
+// in the order added, and the last matched rule decide whether the entry
+// is excluded. The contents of an excluded directory are not walked. If
+// any include rules (Include and IncludeRegexp) are added, the files must
+// also match one of them. Directories are not checked by include rules.
+//
+// Glob patterns are gitignore-style: a pattern without "/" matches the base
+// name at any depth, and a pattern with "/" matches the path in each top
+// directory, as .gitignore in it (e.g. "/build" and "docs/*.md" match
+// "top/build" and "top/docs/a.md"). "*" and "?" do not match "/", and "**"
+// matches any number of directories. A pattern ends with "/" matches only
+// directories.
+type Filter struct {
+	excludes []filterRule
+	includes []filterRule
+}
+
+// filterRule is a rule of Filter.
+type filterRule struct {
+	re       *regexp.Regexp
+	dirOnly  bool
+	anchored bool // matched with the path in the top directory
+	negate   bool // re-include the matched entries
+}
+
+func (r *filterRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		// the top entry itself is not in the top directory
+		i := strings.Index(rel, "/")
+		if i < 0 {
+			return false
+		}
+		rel = rel[i+1:]
+	}
+	return r.re.MatchString(rel)
+}
+
+// Include add glob patterns of the files to transfer.
+func (f *Filter) Include(patterns ...string) error {
+	return f.addGlobs(&f.includes, patterns)
+}
+
+// Exclude add glob patterns of the files and directories not to transfer.
+func (f *Filter) Exclude(patterns ...string) error {
+	return f.addGlobs(&f.excludes, patterns)
+}
+
+// IncludeRegexp add regular expressions of the files to transfer. They are
+// matched with the relative paths.
+func (f *Filter) IncludeRegexp(res ...*regexp.Regexp) {
+	for _, re := range res {
+		f.includes = append(f.includes, filterRule{re: re})
+	}
+}
+
+// ExcludeRegexp add regular expressions of the files and directories not
+// to transfer. They are matched with the relative paths.
+func (f *Filter) ExcludeRegexp(res ...*regexp.Regexp) {
+	for _, re := range res {
+		f.excludes = append(f.excludes, filterRule{re: re})
+	}
+}
+
+// AddGitignore add the lines of r as exclude rules, in the format of
+// .gitignore. Blank lines and comments are ignored, and the patterns start
+// with "!" include the entries excluded by the previous rules.
+func (f *Filter) AddGitignore(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+
+		rule, err := globRule(line)
+		if err != nil {
+			return err
+		}
+		rule.negate = negate
+		f.excludes = append(f.excludes, rule)
+	}
+	return scanner.Err()
+}
+
+func (f *Filter) addGlobs(rules *[]filterRule, patterns []string) error {
+	for _, pattern := range patterns {
+		rule, err := globRule(pattern)
+		if err != nil {
+			return err
+		}
+		*rules = append(*rules, rule)
+	}
+	return nil
+}
+
+// Match report whether the entry at rel is transferred.
+func (f *Filter) Match(rel string, isDir bool) bool {
+	if f == nil {
+		return true
+	}
+
+	excluded := false
+	for i := range f.excludes {
+		if f.excludes[i].match(rel, isDir) {
+			excluded = !f.excludes[i].negate
+		}
+	}
+	if excluded {
+		return false
+	}
+
+	if isDir || len(f.includes) == 0 {
+		return true
+	}
+	for i := range f.includes {
+		if f.includes[i].match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRule compile the glob pattern to filterRule.
+func globRule(pattern string) (filterRule, error) {
+	rule := filterRule{}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// pattern without "/" matches the base name at any depth
+	rule.anchored = strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" || pattern != path.Clean(pattern) {
+		return rule, &FilterError{Pattern: pattern}
+	}
+
+	expr, err := globRegexp(pattern)
+	if err != nil {
+		return rule, err
+	}
+	if !rule.anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	rule.re, err = regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return rule, &FilterError{Pattern: pattern}
+	}
+	return rule, nil
+}
+
+// globRegexp convert the glob pattern to regular expression.
+func globRegexp(pattern string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if !strings.HasPrefix(pattern[i:], "**") {
+				b.WriteString("[^/]*")
+				continue
+			}
+
+			// "**" is only special as a whole path element
+			start := i == 0 || pattern[i-1] == '/'
+			i++
+			switch {
+			case start && strings.HasPrefix(pattern[i+1:], "/"):
+				b.WriteString("(?:.*/)?")
+				i++
+			case start && i+1 == len(pattern):
+				b.WriteString(".*")
+			default:
+				b.WriteString("[^/]*")
+			}
+
+		case '?':
+			b.WriteString("[^/]")
+
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", &FilterError{Pattern: pattern}
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// FilterError is an invalid pattern of Filter.
+type FilterError struct {
+	Pattern string
+}
+
+func (e *FilterError) Error() string {
+	return "scplib: invalid filter pattern: " + e.Pattern
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		exclude []string
+		include []string
+		rel     string
+		dir     bool
+		want    bool
+	}{
+		{rel: "top/a", want: true},
+
+		// base name at any depth
+		{exclude: []string{".git"}, rel: ".git", dir: true, want: false},
+		{exclude: []string{".git"}, rel: "top/.git", dir: true, want: false},
+		{exclude: []string{"*.swp"}, rel: "top/sub/.a.swp", want: false},
+		{exclude: []string{"*.swp"}, rel: "top/sub/a.swpx", want: true},
+		{exclude: []string{"a?c"}, rel: "top/abc", want: false},
+		{exclude: []string{"[ab].txt"}, rel: "top/b.txt", want: false},
+		{exclude: []string{"[!ab].txt"}, rel: "top/b.txt", want: true},
+
+		// anchored to the top directory
+		{exclude: []string{"sub/build"}, rel: "top/sub/build", dir: true, want: false},
+		{exclude: []string{"sub/build"}, rel: "top/a/sub/build", dir: true, want: true},
+		{exclude: []string{"sub/build"}, rel: "sub/build", dir: true, want: true},
+		{exclude: []string{"/build"}, rel: "top/build", want: false},
+		{exclude: []string{"/build"}, rel: "top/sub/build", want: true},
+		{exclude: []string{"/build"}, rel: "build", want: true},
+		{exclude: []string{"sub/*.o"}, rel: "top/sub/a.o", want: false},
+		{exclude: []string{"sub/*.o"}, rel: "top/sub/x/a.o", want: true},
+
+		// "**"
+		{exclude: []string{"**/node_modules"}, rel: "top/node_modules", dir: true, want: false},
+		{exclude: []string{"**/node_modules"}, rel: "top/a/b/node_modules", dir: true, want: false},
+		{exclude: []string{"sub/**/*.o"}, rel: "top/sub/a/b/c.o", want: false},
+		{exclude: []string{"sub/**/*.o"}, rel: "top/sub/c.o", want: false},
+		{exclude: []string{"sub/**"}, rel: "top/sub/a/b", want: false},
+		{exclude: []string{"sub/**"}, rel: "top/sub", dir: true, want: true},
+
+		// only directories
+		{exclude: []string{"build/"}, rel: "top/build", dir: true, want: false},
+		{exclude: []string{"build/"}, rel: "top/build", want: true},
+
+		// include files, not directories
+		{include: []string{"*.go"}, rel: "top/a.go", want: true},
+		{include: []string{"*.go"}, rel: "top/a.txt", want: false},
+		{include: []string{"*.go"}, rel: "top/sub", dir: true, want: true},
+		{include: []string{"*.go"}, exclude: []string{"*_test.go"}, rel: "top/a_test.go", want: false},
+	}
+
+	for _, test := range tests {
+		f := &Filter{}
+		if err := f.Exclude(test.exclude...); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Include(test.include...); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Match(test.rel, test.dir); got != test.want {
+			t.Errorf("exclude %q include %q: Match(%q, %v) = %v, want %v",
+				test.exclude, test.include, test.rel, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestFilterGitignore(t *testing.T) {
+	f := &Filter{}
+	err := f.AddGitignore(strings.NewReader("# comment\n\n*.log\n!keep.log\nbuild/\n\\!bang\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel  string
+		dir  bool
+		want bool
+	}{
+		{rel: "top/a.log", want: false},
+		{rel: "top/keep.log", want: true},
+		{rel: "top/build", dir: true, want: false},
+		{rel: "top/!bang", want: false},
+		{rel: "top/comment", want: true},
+	}
+	for _, test := range tests {
+		if got := f.Match(test.rel, test.dir); got != test.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", test.rel, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestFilterRegexp(t *testing.T) {
+	f := &Filter{}
+	f.ExcludeRegexp(regexp.MustCompile(`(^|/)tmp-[0-9]+$`))
+	f.IncludeRegexp(regexp.MustCompile(`\.(c|h)$`))
+
+	if f.Match("top/tmp-1", true) {
+		t.Error("excluded directory is matched")
+	}
+	if !f.Match("top/a.c", false) || f.Match("top/a.o", false) {
+		t.Error("include regexp is not applied")
+	}
+
+	var nilFilter *Filter
+	if !nilFilter.Match("a", false) {
+		t.Error("nil filter does not match")
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "/", "a/../b", "[ab"} {
+		f := &Filter{}
+		if _, ok := f.Exclude(pattern).(*FilterError); !ok {
+			t.Errorf("Exclude(%q) is not *FilterError", pattern)
+		}
+	}
+}
+
+func TestFilterPush(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a.go":          "a",
+		"top/a.swp":         "s",
+		"top/.git/config":   "c",
+		"top/sub/b.go":      "b",
+		"top/sub/b.txt":     "t",
+		"top/vendor/x/x.go": "x",
+	})
+	defer os.RemoveAll(dir)
+
+	filter := &Filter{}
+	filter.Exclude(".git", "*.swp", "/vendor")
+	filter.Include("*.go")
+
+	f := &fakeSink{}
+	err := runFakeSink(f, AbortOnError, func(c *source) error {
+		p := &pusher{c: c, filter: filter}
+		return p.push(filepath.Join(dir, "top"), "top")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"D0755 0 top",
+		"C0644 1 a.go",
+		"D0755 0 sub",
+		"C0644 1 b.go",
+		"E",
+		"E",
+	}
+	if !reflect.DeepEqual(f.lines, want) {
+		t.Errorf("lines = %q, want %q", f.lines, want)
+	}
+}
+
+func TestFilterSink(t *testing.T) {
+	f := &fakeSource{msgs: []string{
+		"D0755 0 top\n",
+		fileMsg("a.go", "a"),
+		fileMsg("a.swp", "swap"),
+		"D0755 0 .git\n",
+		fileMsg("config", "c"),
+		"D0755 0 objects\n",
+		"E\n",
+		"E\n",
+		"T1500000000 0 1500000000 0\n",
+		fileMsg("b.go", "b"),
+		"E\n",
+	}}
+
+	filter := &Filter{}
+	filter.Exclude(".git", "*.swp")
+
+	got := new(bytes.Buffer)
+	err := runFakeSourceWith(f, &rawWriter{w: got}, func(k *sink) {
+		k.filter = filter
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.err != nil {
+		t.Fatal(f.err)
+	}
+
+	want := "D0755 0 top\n" +
+		fileMsg("a.go", "a") +
+		"T1500000000 0 1500000000 0\n" +
+		fileMsg("b.go", "b") +
+		"E\n"
+	if got.String() != want {
+		t.Errorf("data = %q, want %q", got.String(), want)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrReadOnly is the error of the writing methods of read-only FS.
+var ErrReadOnly = errors.New("scplib: read-only file system")
+
+// FS is the file system, that the files are read from and written to. The
+// names are the paths of the local side, in the form of filepath. The errors
+// should be *os.PathError, as returned by the os package.
+type FS interface {
+	// Open open the file to read.
+	Open(name string) (io.ReadCloser, error)
+
+	// Create create or truncate the file to write. perm is the permission
+	// of the created file.
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+
+	Mkdir(name string, perm os.FileMode) error
+	Lstat(name string) (os.FileInfo, error)
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+
+	// ReadDir return the entries of the directory, sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	Rename(oldpath, newpath string) error
+}
+
+// StatFS is FS that can follow symbolic links. Symbolic links in FS without
+// Stat are not followed.
+type StatFS interface {
+	FS
+	Stat(name string) (os.FileInfo, error)
+}
+
+// RemoveFS is FS that can remove files. The partial files of the failed
+// transfers are removed only from RemoveFS.
+type RemoveFS interface {
+	FS
+	Remove(name string) error
+}
+
+// AppendFS is FS that can append to files. The partial files are resumed
+// by GetFile only in AppendFS.
+type AppendFS interface {
+	FS
+	Append(name string) (io.WriteCloser, error)
+}
+
+// OSFS is FS of the local file system, used by default.
+type OSFS struct{}
+
+// Open open the file to read, with os.Open.
+func (OSFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// Create create or truncate the file to write, with os.OpenFile.
+func (OSFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, perm)
+}
+
+// Mkdir is os.Mkdir.
+func (OSFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+// Lstat is os.Lstat.
+func (OSFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// Stat is os.Stat.
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Append open the file to append, with os.OpenFile.
+func (OSFS) Append(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// Chmod is os.Chmod.
+func (OSFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chtimes is os.Chtimes.
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// ReadDir is ioutil.ReadDir.
+func (OSFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// Rename is os.Rename.
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove is os.Remove.
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// createTemp create a new temporary file with the exclusive flag, for the
+// destination path.
+func (OSFS) createTemp(path string) (string, io.WriteCloser, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".scplib-")
+	if err != nil {
+		return "", nil, err
+	}
+	return file.Name(), file, nil
+}
+
+// createTemp create a new temporary file in the directory of path, with a
+// random name not used in fsys.
+func createTemp(fsys FS, path string) (string, io.WriteCloser, error) {
+	if t, ok := fsys.(interface {
+		createTemp(path string) (string, io.WriteCloser, error)
+	}); ok {
+		return t.createTemp(path)
+	}
+
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".scplib-")
+	for i := 0; i < 10000; i++ {
+		temp := prefix + strconv.FormatUint(uint64(rand.Uint32()), 10)
+		if _, err := fsys.Lstat(temp); errors.Is(err, os.ErrNotExist) {
+			file, err := fsys.Create(temp, 0600)
+			return temp, file, err
+		}
+	}
+	return "", nil, &os.PathError{Op: "createtemp", Path: prefix + "*", Err: os.ErrExist}
+}
+
+// localFS return fsys, or OSFS if nil.
+func localFS(fsys FS) FS {
+	if fsys == nil {
+		return OSFS{}
+	}
+	return fsys
+}
+
+// statFile return the info of name, following symbolic links if fsys is
+// StatFS.
+func statFile(fsys FS, name string) (os.FileInfo, error) {
+	if s, ok := fsys.(StatFS); ok {
+		return s.Stat(name)
+	}
+	return fsys.Lstat(name)
+}
+
+// removeFile remove name, if fsys is RemoveFS.
+func removeFile(fsys FS, name string) error {
+	if r, ok := fsys.(RemoveFS); ok {
+		return r.Remove(name)
+	}
+	return nil
+}
+
+// evalSymlinks return name with the symbolic links resolved. Only the links
+// of OSFS are resolved, and the names in the other FS are returned as is.
+func evalSymlinks(fsys FS, name string) (string, error) {
+	if _, ok := fsys.(OSFS); ok {
+		return filepath.EvalSymlinks(name)
+	}
+	return name, nil
+}
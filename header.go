@@ -0,0 +1,79 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Type of the Header.
+const (
+	TypeFile = 'C' // regular file, followed by its data
+	TypeDir  = 'D' // start of directory
+	TypeEnd  = 'E' // end of directory
+)
+
+// Header is a record of scp format data. The time record (`T`) is not a
+// Header by itself, but is set to ModTime and AccessTime of the following
+// file or directory.
+type Header struct {
+	Type byte        // TypeFile, TypeDir or TypeEnd
+	Mode os.FileMode // permission bits
+	Size int64       // data size of TypeFile
+	Name string      // base name of TypeFile and TypeDir
+
+	// ModTime and AccessTime are zero, if not sent.
+	ModTime    time.Time
+	AccessTime time.Time
+}
+
+// newHeader return Header of C, D or E record.
+func newHeader(rec record) *Header {
+	return &Header{
+		Type: rec.typ,
+		Mode: rec.mode,
+		Size: rec.size,
+		Name: rec.name,
+	}
+}
+
+// line return the protocol line of hdr, without newline.
+func (hdr *Header) line() string {
+	switch hdr.Type {
+	case TypeEnd:
+		return "E"
+	case TypeDir:
+		return fmt.Sprintf("D%04o 0 %s", hdr.Mode.Perm(), hdr.Name)
+	}
+	return fmt.Sprintf("C%04o %d %s", hdr.Mode.Perm(), hdr.Size, hdr.Name)
+}
+
+// hasTimes report whether hdr has times to send with the time record.
+func (hdr *Header) hasTimes() bool {
+	return hdr.Type != TypeEnd && !(hdr.ModTime.IsZero() && hdr.AccessTime.IsZero())
+}
+
+// times return the access and modification times of hdr. If one of them
+// is zero, the other is used.
+func (hdr *Header) times() (atime, mtime time.Time) {
+	atime, mtime = hdr.AccessTime, hdr.ModTime
+	if mtime.IsZero() {
+		mtime = atime
+	} else if atime.IsZero() {
+		atime = mtime
+	}
+	return
+}
+
+// timeLine return the time record of hdr, without newline.
+func (hdr *Header) timeLine() string {
+	atime, mtime := hdr.times()
+	return fmt.Sprintf("T%d %d %d %d",
+		mtime.Unix(), mtime.Nanosecond()/int(time.Microsecond),
+		atime.Unix(), atime.Nanosecond()/int(time.Microsecond),
+	)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package scplib
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// IOFS is read-only FS of io/fs.FS, e.g. embed.FS, to upload its files by
+// PutFile. The names are slash-separated in fsys, and the leading `/` is
+// removed. The writing methods return ErrReadOnly.
+type IOFS struct {
+	fsys fs.FS
+}
+
+// NewIOFS return IOFS reading fsys.
+//
+// example:
+//
+//	//go:embed static
+//	var static embed.FS
+//
+//	scp.FS = scplib.NewIOFS(static)
+//	err := scp.PutFile([]string{"static"}, "/To/Remote/Path")
+func NewIOFS(fsys fs.FS) *IOFS {
+	return &IOFS{fsys: fsys}
+}
+
+// name return the name in fsys.
+func (f *IOFS) name(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+// Open open the file to read.
+func (f *IOFS) Open(name string) (io.ReadCloser, error) {
+	file, err := f.fsys.Open(f.name(name))
+	if err != nil {
+		return nil, err
+	}
+	return &ioFile{File: file, name: name}, nil
+}
+
+// Lstat return the info of the file, by fs.Stat.
+func (f *IOFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, f.name(name))
+}
+
+// Stat return the info of the file, by fs.Stat.
+func (f *IOFS) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, f.name(name))
+}
+
+// ReadDir return the entries of the directory, sorted by name.
+func (f *IOFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(f.fsys, f.name(name))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Create return ErrReadOnly.
+func (f *IOFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+}
+
+// Mkdir return ErrReadOnly.
+func (f *IOFS) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+// Chmod return ErrReadOnly.
+func (f *IOFS) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: ErrReadOnly}
+}
+
+// Chtimes return ErrReadOnly.
+func (f *IOFS) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: ErrReadOnly}
+}
+
+// Rename return ErrReadOnly.
+func (f *IOFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrReadOnly}
+}
+
+// ioFile is fs.File with the name passed to Open.
+type ioFile struct {
+	fs.File
+	name string
+}
+
+// Name return the name passed to Open.
+func (f *ioFile) Name() string {
+	return f.name
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package scplib
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	fsys := NewIOFS(fstest.MapFS{
+		"top/a":     {Data: []byte("aaa"), Mode: 0600},
+		"top/sub/b": {Data: []byte("bb"), Mode: 0644},
+	})
+
+	s := &SCPClient{Permission: true, FS: fsys}
+	f := &fakeSink{}
+	if err := runFakeSink(f, AbortOnError, s.putFiles([]string{"/top"})); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a": "aaa", "b": "bb"}; !reflect.DeepEqual(f.data, want) {
+		t.Errorf("data = %q, want %q", f.data, want)
+	}
+
+	// the plan keep the names to open
+	plan, err := s.PutFilePlan(context.Background(), []string{"top"}, "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := planOp(plan, "top/sub/b"); op == nil || op.src != "top/sub/b" {
+		t.Errorf("op = %+v", op)
+	}
+
+	if _, err := fsys.Create("top/c", 0644); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("create: err = %v, want %v", err, ErrReadOnly)
+	}
+	if err := fsys.Rename("top/a", "top/c"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("rename: err = %v, want %v", err, ErrReadOnly)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNotEmpty is the error of MemFS, removing or replacing a directory that
+// is not empty. syscall.ENOTEMPTY is not used, as it is not defined on all
+// platforms.
+var ErrNotEmpty = errors.New("scplib: directory not empty")
+
+// MemFS is FS in memory, e.g. to receive the files without local disk, and
+// for tests. The zero value is an empty file system with the root
+// directory. The names are cleaned, and the relative names are in the root
+// directory. Symbolic links are not supported.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile // by cleaned absolute slash path
+}
+
+// memFile is a file or directory of MemFS.
+type memFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// key return the key of name in files.
+func memKey(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// lookup return the file of name. m.mu must be held.
+func (m *MemFS) lookup(op, name string) (string, *memFile, error) {
+	if m.files == nil {
+		m.files = map[string]*memFile{"/": {mode: os.ModeDir | 0755, modTime: time.Now()}}
+	}
+
+	key := memKey(name)
+	if err := m.checkParent(op, name, key); err != nil {
+		return key, nil, err
+	}
+	f, ok := m.files[key]
+	if !ok {
+		return key, nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return key, f, nil
+}
+
+// checkParent check that the parent of key is a directory. m.mu must be
+// held.
+func (m *MemFS) checkParent(op, name, key string) error {
+	if key == "/" {
+		return nil
+	}
+	parent, ok := m.files[path.Dir(key)]
+	switch {
+	case !ok:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case !parent.mode.IsDir():
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// create add the file or directory of name, if the parent exists. m.mu
+// must be held.
+func (m *MemFS) create(op, name string, mode os.FileMode) (*memFile, error) {
+	key, _, err := m.lookup(op, name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err = m.checkParent(op, name, key); err != nil {
+		return nil, err
+	}
+
+	f := &memFile{mode: mode, modTime: time.Now()}
+	m.files[key] = f
+	return f, nil
+}
+
+// Open open the file to read. The data is read as at the time of Open.
+func (m *MemFS) Open(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	return &memReader{
+		Reader: bytes.NewReader(f.data),
+		name:   name,
+		info:   f.info(key),
+	}, nil
+}
+
+// Create create or truncate the file to write.
+func (m *MemFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, f, err := m.lookup("open", name)
+	switch {
+	case err == nil && f.mode.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil:
+		f.data, f.modTime = nil, time.Now()
+	default:
+		if f, err = m.create("open", name, perm.Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return &memWriter{m: m, f: f}, nil
+}
+
+// Append open the file to append.
+func (m *MemFS) Append(name string) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	return &memWriter{m: m, f: f}, nil
+}
+
+// Mkdir create the directory.
+func (m *MemFS) Mkdir(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, _, err := m.lookup("mkdir", name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	_, err := m.create("mkdir", name, os.ModeDir|perm.Perm())
+	return err
+}
+
+// MkdirAll create the directory and its parents, as os.MkdirAll.
+func (m *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	if info, err := m.Lstat(name); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	if key := memKey(name); key != "/" {
+		if err := m.MkdirAll(path.Dir(key), perm); err != nil {
+			return err
+		}
+	}
+	if err := m.Mkdir(name, perm); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// Lstat return the info of the file.
+func (m *MemFS) Lstat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, f, err := m.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(key), nil
+}
+
+// Stat is same as Lstat.
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	return m.Lstat(name)
+}
+
+// Chmod change the permission of the file.
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, f, err := m.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	f.mode = f.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+// Chtimes change the modification time of the file. The access time is
+// not kept.
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, f, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	f.modTime = mtime
+	return nil
+}
+
+// ReadDir return the entries of the directory, sorted by name.
+func (m *MemFS) ReadDir(name string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	var infos []os.FileInfo
+	for k, f := range m.files {
+		if k != "/" && path.Dir(k) == key {
+			infos = append(infos, f.info(k))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Rename move the file or directory to newpath. The existing file at
+// newpath is replaced, and the existing directory must be empty.
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldKey, f, err := m.lookup("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newKey, dst, err := m.lookup("rename", newpath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err = m.checkParent("rename", newpath, newKey); err != nil {
+		return err
+	}
+
+	switch {
+	case oldKey == newKey:
+		return nil
+	case oldKey == "/" || strings.HasPrefix(newKey, oldKey+"/"):
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EINVAL}
+	case dst != nil && dst.mode.IsDir() != f.mode.IsDir():
+		errno := syscall.EISDIR
+		if !dst.mode.IsDir() {
+			errno = syscall.ENOTDIR
+		}
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errno}
+	case dst != nil && dst.mode.IsDir() && m.hasChildren(newKey):
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrNotEmpty}
+	}
+
+	// the entries in the directory are moved together
+	for k, child := range m.files {
+		if strings.HasPrefix(k, oldKey+"/") {
+			delete(m.files, k)
+			m.files[newKey+k[len(oldKey):]] = child
+		}
+	}
+	delete(m.files, oldKey)
+	m.files[newKey] = f
+	return nil
+}
+
+// Remove remove the file or the empty directory.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, _, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if key == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	if m.hasChildren(key) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+	}
+	delete(m.files, key)
+	return nil
+}
+
+// hasChildren report whether the directory of key has entries. m.mu must
+// be held.
+func (m *MemFS) hasChildren(key string) bool {
+	for k := range m.files {
+		if k != "/" && path.Dir(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadFile return the data of the file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	r, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// WriteFile write data to the file, as ioutil.WriteFile.
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	w, err := m.Create(name, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// info return the info of f at key.
+func (f *memFile) info(key string) os.FileInfo {
+	return &memInfo{name: path.Base(key), size: int64(len(f.data)), mode: f.mode, modTime: f.modTime}
+}
+
+// memInfo is os.FileInfo of memFile.
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() os.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() interface{}   { return nil }
+
+// memReader read the data of memFile.
+type memReader struct {
+	*bytes.Reader
+	name string
+	info os.FileInfo
+}
+
+// Name return the name passed to Open.
+func (r *memReader) Name() string {
+	return r.name
+}
+
+// Stat return the info at the time of Open.
+func (r *memReader) Stat() (os.FileInfo, error) {
+	return r.info, nil
+}
+
+func (r *memReader) Close() error {
+	return nil
+}
+
+// memWriter append the data to memFile.
+type memWriter struct {
+	m      *MemFS
+	f      *memFile
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	w.f.data = append(w.f.data, p...)
+	w.f.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	return nil
+}
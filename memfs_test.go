@@ -0,0 +1,152 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMemFS(t *testing.T) {
+	m := &MemFS{}
+	if err := m.MkdirAll("/top/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"top/b": "bb", "/top/a": "aaa", "top/sub/c": ""} {
+		if err := m.WriteFile(name, []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := m.ReadDir("top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if want := []string{"a", "b", "sub"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if infos[0].Size() != 3 || infos[0].Mode() != 0640 || infos[2].Mode() != os.ModeDir|0700 {
+		t.Errorf("infos = %v, %v, %v", infos[0], infos[1], infos[2])
+	}
+
+	// the errors are same as os
+	errTests := []struct {
+		err  error
+		want error
+	}{
+		{m.Mkdir("top", 0755), os.ErrExist},
+		{m.Mkdir("none/dir", 0755), os.ErrNotExist},
+		{m.Mkdir("top/a/dir", 0755), syscall.ENOTDIR},
+		{m.Remove("top"), ErrNotEmpty},
+		{m.Rename("top/sub", "top"), ErrNotEmpty},
+		{m.Rename("top", "top/sub/top"), syscall.EINVAL},
+		{m.Chmod("none", 0644), os.ErrNotExist},
+	}
+	for i, test := range errTests {
+		if !errors.Is(test.err, test.want) {
+			t.Errorf("%d: err = %v, want %v", i, test.err, test.want)
+		}
+	}
+	if _, err := m.Create("top/sub", 0644); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("create of directory: err = %v", err)
+	}
+
+	// the directory is moved with its entries
+	if err = m.Rename("top", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := m.ReadFile("moved/a"); err != nil || string(data) != "aaa" {
+		t.Errorf("moved/a = %q, %v", data, err)
+	}
+	if _, err = m.Lstat("top/sub/c"); !os.IsNotExist(err) {
+		t.Errorf("top/sub/c is not moved: %v", err)
+	}
+
+	w, err := m.Append("moved/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("b"))
+	w.Close()
+	if _, err = w.Write([]byte("b")); err == nil {
+		t.Error("write after close")
+	}
+	if data, _ := m.ReadFile("moved/b"); string(data) != "bbb" {
+		t.Errorf("moved/b = %q, want bbb", data)
+	}
+}
+
+func TestMemFSPut(t *testing.T) {
+	m := &MemFS{}
+	m.MkdirAll("/src/top/sub", 0755)
+	m.WriteFile("/src/top/a", []byte("aaa"), 0600)
+	m.WriteFile("/src/top/sub/b", []byte("bb"), 0644)
+	mtime := time.Unix(1500000000, 0)
+	for _, name := range []string{"/src/top", "/src/top/a", "/src/top/sub", "/src/top/sub/b"} {
+		m.Chtimes(name, mtime, mtime)
+	}
+
+	s := &SCPClient{Permission: true, FS: m}
+	f := &fakeSink{}
+	err := runFakeSink(f, AbortOnError, s.putFiles([]string{"/src/top", "/src/none"}))
+	if lerr, ok := err.(*LocalIOError); !ok || lerr.Path != "/src/none" {
+		t.Errorf("err = %v, want *LocalIOError of /src/none", err)
+	}
+
+	wantLines := []string{
+		"T1500000000 0 1500000000 0",
+		"D0755 0 top",
+		"T1500000000 0 1500000000 0",
+		"C0600 3 a",
+		"T1500000000 0 1500000000 0",
+		"D0755 0 sub",
+		"T1500000000 0 1500000000 0",
+		"C0644 2 b",
+		"E",
+		"E",
+	}
+	if !reflect.DeepEqual(f.lines, wantLines) {
+		t.Errorf("lines = %q, want %q", f.lines, wantLines)
+	}
+	if want := map[string]string{"a": "aaa", "b": "bb"}; !reflect.DeepEqual(f.data, want) {
+		t.Errorf("data = %q, want %q", f.data, want)
+	}
+}
+
+func TestMemFSGet(t *testing.T) {
+	m := &MemFS{}
+	m.MkdirAll("dest", 0755)
+
+	f := &fakeSource{msgs: []string{
+		"D0700 0 sub\n",
+		"T1500000000 0 1500000000 0\n",
+		fileMsg("a.txt", "hello"),
+		"E\n",
+	}}
+	if err := runFakeSource(f, &fileWriter{fs: m, path: "dest", perm: true, atomic: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := m.ReadFile("dest/sub/a.txt"); err != nil || string(data) != "hello" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+	info, err := m.Stat("dest/sub/a.txt")
+	if err != nil || info.Mode() != 0644 || !info.ModTime().Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("info = %v, %v", info, err)
+	}
+
+	// the temporary file is renamed
+	if infos, _ := m.ReadDir("dest/sub"); len(infos) != 1 {
+		t.Errorf("%d files in dest/sub", len(infos))
+	}
+}
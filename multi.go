@@ -0,0 +1,235 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrStreamNotSeekable is returned for the hosts of MultiClient.PutStream
+// after the first Concurrency hosts, if the stream is not io.Seeker.
+var ErrStreamNotSeekable = errors.New("scplib: stream can not be read again for the next hosts")
+
+// errNoHosts stop writing the data, when all hosts have failed.
+var errNoHosts = errors.New("scplib: no hosts to send")
+
+// MultiClient put the same files or data to many hosts at the same time.
+// The local files are read once, and the scp format data is copied to the
+// session of each host. The transfers run as fast as the slowest host.
+type MultiClient struct {
+	// Connections are the connections to the hosts.
+	Connections []*ssh.Client
+
+	// Options is the options of the transfers, e.g. Permission, SCPPath,
+	// ErrorPolicy and Filter. Connection and Session of it are not used,
+	// and Verify, Resume and Sessions are ignored. Progress receive the
+	// progress of reading the local files.
+	Options SCPClient
+
+	// Concurrency is the maximum number of hosts transferred at the same
+	// time. Zero means all hosts. The local files are read again for each
+	// group of Concurrency hosts.
+	Concurrency int
+}
+
+// HostResult is the result of the transfer to a host of MultiClient.
+type HostResult struct {
+	Connection *ssh.Client
+
+	// Err is the error of the transfer to the host, as returned by
+	// SCPClient.PutStream.
+	Err error
+}
+
+// PutFile put files to the remote path of all hosts. The results are in
+// the order of Connections, and err is the error of the local files.
+//
+// example:
+//
+//	results, err := multi.PutFile([]string{"/From/Local/Path"}, "/To/Remote/Path")
+func (m *MultiClient) PutFile(fromPaths []string, toPath string) ([]HostResult, error) {
+	return m.PutFileContext(context.Background(), fromPaths, toPath)
+}
+
+// PutFileContext is PutFile with ctx. If ctx is done before the end, the
+// transfers are stopped, and ctx.Err() is returned.
+func (m *MultiClient) PutFileContext(ctx context.Context, fromPaths []string, toPath string) ([]HostResult, error) {
+	return m.run(ctx, toPath, len(fromPaths) > 1, m.Options.writeFiles(fromPaths))
+}
+
+// writeFiles return the function to write fromPaths as scp format data,
+// in the same way as PutFile send them.
+func (s *SCPClient) writeFiles(fromPaths []string) func(w io.Writer) error {
+	send := s.putFiles(fromPaths)
+	return func(w io.Writer) error {
+		// all records are accepted, and the hosts answer by themselves
+		c := newSource(zeroReader{}, w, s.ErrorPolicy)
+		c.progress = s.progress()
+		return c.errs.with(send(c))
+	}
+}
+
+// PutStream put scp format data read from r to all hosts. r is read once,
+// if there are not more hosts than Concurrency. Otherwise r must be
+// io.Seeker, and it is read again from the current offset for each group
+// of hosts. The results are in the order of Connections, and err is the
+// error of reading r.
+func (m *MultiClient) PutStream(ctx context.Context, r io.Reader, toPath string) ([]HostResult, error) {
+	seeker, _ := r.(io.Seeker)
+	var offset int64
+	if seeker != nil {
+		var err error
+		if offset, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+
+	first := true
+	return m.run(ctx, toPath, false, func(w io.Writer) error {
+		if !first {
+			if seeker == nil {
+				return ErrStreamNotSeekable
+			}
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// run run the transfers to the hosts in groups of Concurrency. write write
+// the data of a group. If targetDir, toPath must be a directory.
+func (m *MultiClient) run(ctx context.Context, toPath string, targetDir bool, write func(w io.Writer) error) ([]HostResult, error) {
+	results := make([]HostResult, len(m.Connections))
+	for i, conn := range m.Connections {
+		results[i].Connection = conn
+	}
+
+	n := m.Concurrency
+	if n <= 0 || n > len(results) {
+		n = len(results)
+	}
+
+	var localErr error
+	for start := 0; start < len(results); start += n {
+		end := start + n
+		if end > len(results) {
+			end = len(results)
+		}
+		group := results[start:end]
+
+		if err := ctx.Err(); err != nil {
+			for i := range group {
+				group[i].Err = err
+			}
+			continue
+		}
+
+		err := fanOut(ctx, len(group), write, func(ctx context.Context, i int, r io.Reader) error {
+			return m.client(group[i].Connection).putStream(ctx, r, toPath, targetDir)
+		}, func(i int, err error) {
+			group[i].Err = err
+		})
+		if localErr == nil {
+			localErr = err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	return results, localErr
+}
+
+// client return SCPClient of the host at conn, with Options.
+func (m *MultiClient) client(conn *ssh.Client) *SCPClient {
+	c := m.Options
+	c.Connection, c.Session = conn, nil
+	c.Progress = nil
+	return &c
+}
+
+// fanOut run put for n hosts at the same time, and copy the data written
+// by write to all of them. The result of each host is passed to done. The
+// failed hosts are dropped, and the error of write is returned.
+func fanOut(ctx context.Context, n int, write func(w io.Writer) error, put func(ctx context.Context, i int, r io.Reader) error, done func(i int, err error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t := &teeWriter{ws: make([]*io.PipeWriter, n)}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		pr, pw := io.Pipe()
+		t.ws[i] = pw
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := put(ctx, i, pr)
+
+			// the data is not sent to the finished host
+			pr.CloseWithError(errNoHosts)
+			done(i, err)
+		}(i)
+	}
+
+	err := write(t)
+	t.close(err)
+	wg.Wait()
+
+	if err == errNoHosts {
+		err = nil
+	}
+	return err
+}
+
+// teeWriter write the same data to the pipes of all hosts at the same time.
+// The pipe of the failed host is dropped.
+type teeWriter struct {
+	ws []*io.PipeWriter // nil for the dropped hosts
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	var wg sync.WaitGroup
+	for i, w := range t.ws {
+		if w == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, w *io.PipeWriter) {
+			defer wg.Done()
+			if _, err := w.Write(p); err != nil {
+				t.ws[i] = nil
+			}
+		}(i, w)
+	}
+	wg.Wait()
+
+	for _, w := range t.ws {
+		if w != nil {
+			return len(p), nil
+		}
+	}
+	return 0, errNoHosts
+}
+
+// close close the pipes with err, or with io.EOF if err is nil.
+func (t *teeWriter) close(err error) {
+	for _, w := range t.ws {
+		if w != nil {
+			w.CloseWithError(err)
+		}
+	}
+}
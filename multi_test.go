@@ -0,0 +1,110 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a": "aaa",
+		"top/b": "bb",
+	})
+	defer os.RemoveAll(dir)
+
+	sinks := []*fakeSink{
+		{},
+		{reject: map[string]byte{"b": respWarning}},
+		{reject: map[string]byte{"top": respFatal}},
+	}
+	errs := make([]error, len(sinks))
+
+	reads := 0
+	s := &SCPClient{ErrorPolicy: ContinueOnError}
+	write := s.writeFiles([]string{filepath.Join(dir, "top")})
+	err := fanOut(context.Background(), len(sinks), func(w io.Writer) error {
+		reads++
+		return write(w)
+	}, func(ctx context.Context, i int, r io.Reader) error {
+		return runFakeSink(sinks[i], ContinueOnError, func(c *source) error {
+			return c.sendData(r)
+		})
+	}, func(i int, err error) {
+		errs[i] = err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reads != 1 {
+		t.Errorf("local files are written %d times", reads)
+	}
+
+	if errs[0] != nil || sinks[0].data["a"] != "aaa" || sinks[0].data["b"] != "bb" {
+		t.Errorf("host 0: err = %v, data = %q", errs[0], sinks[0].data)
+	}
+	if rerr, ok := errs[1].(*RemoteError); !ok || rerr.Path != "top/b" {
+		t.Errorf("host 1: err = %v, want *RemoteError of top/b", errs[1])
+	}
+	if want := map[string]string{"a": "aaa"}; !reflect.DeepEqual(sinks[1].data, want) {
+		t.Errorf("host 1: data = %q, want %q", sinks[1].data, want)
+	}
+	if rerr, ok := errs[2].(*RemoteError); !ok || rerr.Severity != SeverityFatal {
+		t.Errorf("host 2: err = %v, want fatal *RemoteError", errs[2])
+	}
+}
+
+func TestFanOutAllFailed(t *testing.T) {
+	errHost := errors.New("host failed")
+	errs := make([]error, 2)
+
+	err := fanOut(context.Background(), 2, func(w io.Writer) error {
+		for {
+			if _, err := w.Write([]byte("data")); err != nil {
+				return err
+			}
+		}
+	}, func(ctx context.Context, i int, r io.Reader) error {
+		return errHost
+	}, func(i int, err error) {
+		errs[i] = err
+	})
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if errs[0] != errHost || errs[1] != errHost {
+		t.Errorf("errs = %v", errs)
+	}
+}
+
+func TestTeeWriter(t *testing.T) {
+	pr1, pw1 := io.Pipe()
+	pr2, pw2 := io.Pipe()
+	tw := &teeWriter{ws: []*io.PipeWriter{pw1, pw2}}
+
+	// the second host is gone
+	pr2.Close()
+	go func() {
+		if _, err := tw.Write([]byte("data")); err != nil {
+			t.Error(err)
+		}
+		tw.close(nil)
+	}()
+
+	data, err := ioutil.ReadAll(pr1)
+	if err != nil || string(data) != "data" {
+		t.Errorf("data = %q, %v", data, err)
+	}
+	if tw.ws[1] != nil {
+		t.Error("the closed pipe is not dropped")
+	}
+}
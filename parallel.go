@@ -0,0 +1,404 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// parallel run the files of a transfer on several sessions. The files are
+// identified by the indexes in the transfer, and the errors are returned in
+// the order of them.
+type parallel struct {
+	s      *SCPClient // copy of SCPClient, with Progress serialized
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pending []int       // indexes not started yet
+	workers int         // running sessions
+	errs    [][]error   // errors by index
+	sums    []*verifier // checksums by index
+	failed  error       // error of the sessions, not of a file
+}
+
+// newParallel return parallel of n files. v is the verifier of the whole
+// transfer.
+func (s *SCPClient) newParallel(ctx context.Context, n int, v *verifier) *parallel {
+	c := *s
+	c.Sessions = 0
+	if fn := s.Progress; fn != nil {
+		var mu sync.Mutex
+		c.Progress = func(ev ProgressEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(ev)
+		}
+	}
+
+	p := &parallel{s: &c, parent: ctx, errs: make([][]error, n)}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	if v != nil {
+		p.sums = make([]*verifier, n)
+		for i := range p.sums {
+			p.sums[i] = &verifier{alg: v.alg}
+		}
+	}
+	return p
+}
+
+// verifier return the verifier of the file at i, or nil if not required.
+func (p *parallel) verifier(i int) *verifier {
+	if p.sums == nil {
+		return nil
+	}
+	return p.sums[i]
+}
+
+// queue add the indexes to be started.
+func (p *parallel) queue(indexes ...int) {
+	p.mu.Lock()
+	p.pending = append(p.pending, indexes...)
+	p.mu.Unlock()
+}
+
+// next return the next index to be started. ok is false if there are no
+// more, or the transfer is aborted.
+func (p *parallel) next() (i int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ctx.Err() != nil || len(p.pending) == 0 {
+		return 0, false
+	}
+	i, p.pending = p.pending[0], p.pending[1:]
+	return i, true
+}
+
+// retry give i back to the other sessions.
+func (p *parallel) retry(i int) {
+	p.mu.Lock()
+	p.pending = append([]int{i}, p.pending...)
+	p.mu.Unlock()
+}
+
+// fail add err of the file at i. AbortOnError stop all sessions. The
+// errors after the stop are caused by it, and not added.
+func (p *parallel) fail(i int, err error) {
+	p.mu.Lock()
+	if p.ctx.Err() != nil && p.parent.Err() == nil {
+		p.mu.Unlock()
+		return
+	}
+	p.errs[i] = append(p.errs[i], err)
+	p.mu.Unlock()
+
+	if p.s.ErrorPolicy == AbortOnError {
+		p.cancel()
+	}
+}
+
+// run run work on n sessions, and wait for them. The error of work is not
+// of a file. The session refused by the server is ignored, while the other
+// sessions are running.
+func (p *parallel) run(n int, work func() error) {
+	if n > len(p.pending) {
+		n = len(p.pending)
+	}
+	p.workers = n
+
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := work()
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.workers--
+
+			switch {
+			case err == nil:
+			case p.ctx.Err() != nil && p.parent.Err() == nil:
+				// stopped by the other session
+			case isChannelRefused(err) && (p.workers > 0 || len(p.pending) == 0):
+			case p.failed == nil:
+				p.failed = err
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// err return errs and the errors of the transfer, and add the checksums
+// to v.
+func (p *parallel) err(v *verifier, errs errorList) error {
+	p.cancel()
+	if err := p.parent.Err(); err != nil {
+		return err
+	}
+
+	for i, ferrs := range p.errs {
+		for _, err := range ferrs {
+			if p.s.ErrorPolicy == AbortOnError && len(errs) == 0 {
+				return err
+			}
+			errs.add(err)
+		}
+		if len(ferrs) == 0 && p.sums != nil {
+			v.files = append(v.files, p.sums[i].files...)
+		}
+	}
+	if p.failed != nil {
+		errs.add(p.failed)
+	}
+	return errs.err()
+}
+
+// isChannelRefused report whether err is the session refused by the server.
+func isChannelRefused(err error) bool {
+	_, ok := err.(*ssh.OpenChannelError)
+	return ok
+}
+
+// getParallel receive each path in fromPaths on a session, with Sessions
+// sessions at the same time.
+func (s *SCPClient) getParallel(ctx context.Context, fromPaths []string, toPath string, v *verifier) error {
+	p := s.newParallel(ctx, len(fromPaths), v)
+	for i := range fromPaths {
+		p.queue(i)
+	}
+
+	p.run(s.Sessions, func() error {
+		for {
+			i, ok := p.next()
+			if !ok {
+				return nil
+			}
+
+			err := p.s.getFiles(p.ctx, fromPaths[i:i+1], toPath, p.verifier(i))
+			if isChannelRefused(err) {
+				p.retry(i)
+				return err
+			}
+			if err != nil {
+				p.fail(i, err)
+			}
+		}
+	})
+	return p.err(v, nil)
+}
+
+// putParallel send fromPaths to the remote directory toPath on Sessions
+// sessions. The directories are created on a session first, and the files
+// are sent with their parent directories on each session. If toPath is not
+// a directory, the entries are sent on a session.
+func (s *SCPClient) putParallel(ctx context.Context, scpCmd string, fromPaths []string, toPath string, v *verifier) error {
+	isDir, err := s.remoteIsDir(ctx, toPath)
+	if err != nil {
+		return err
+	}
+
+	// the files to be sent, and the local errors of them
+	plan := &Plan{}
+	pc := &source{policy: s.ErrorPolicy, plan: plan, progress: s.progress()}
+	if err = s.putFiles(fromPaths)(pc); err != nil {
+		return err
+	}
+	if !isDir || plan.Files < 2 {
+		return s.runSource(ctx, scpCmd, s.putVerified(fromPaths, v))
+	}
+
+	p := s.newParallel(ctx, len(plan.Ops), v)
+	dirs := map[string]*PlanOp{}
+	var dirOps, fileOps []int
+	for i := range plan.Ops {
+		if op := &plan.Ops[i]; op.Type == TypeDir {
+			dirs[op.Path] = op
+			dirOps = append(dirOps, i)
+		} else {
+			fileOps = append(fileOps, i)
+		}
+	}
+
+	// create the directories in order, before the files in them
+	p.queue(dirOps...)
+	rejected := rejectedDirs{}
+	p.run(1, p.putWorker(scpCmd, plan, dirs, rejected))
+
+	for _, i := range fileOps {
+		if !rejected.contains(plan.Ops[i].Path) {
+			p.queue(i)
+		}
+	}
+	p.run(s.Sessions, p.putWorker(scpCmd, plan, dirs, nil))
+
+	// the times of the directories are changed by the files
+	if s.Permission {
+		for _, i := range dirOps {
+			if !rejected.contains(plan.Ops[i].Path) {
+				p.queue(i)
+			}
+		}
+		p.run(1, p.putWorker(scpCmd, plan, dirs, nil))
+	}
+
+	// the local errors found before the transfer come first
+	return p.err(v, pc.errs)
+}
+
+// putWorker return the work of a session, that send the ops of plan. The
+// directories rejected by the remote are added to rejected, if not nil.
+func (p *parallel) putWorker(scpCmd string, plan *Plan, dirs map[string]*PlanOp, rejected rejectedDirs) func() error {
+	return func() error {
+		failed := false
+		err := p.s.runSource(p.ctx, scpCmd, func(c *source) error {
+			o := &opSender{c: c, fs: p.s.FS, dirs: dirs, rejected: rejectedDirs{}}
+			defer o.rejected.addTo(rejected, &p.mu)
+
+			for {
+				i, ok := p.next()
+				if !ok {
+					return o.close()
+				}
+
+				c.verify = p.verifier(i)
+				n := len(c.errs)
+				err := o.send(&plan.Ops[i])
+				for _, ferr := range c.errs[n:] {
+					failed = true
+					p.fail(i, ferr)
+				}
+				if err != nil {
+					failed = true
+					p.fail(i, err)
+					return err
+				}
+			}
+		})
+
+		// the errors of the files are already added
+		if failed {
+			return nil
+		}
+		return err
+	}
+}
+
+// rejectedDirs is the set of relative paths of the directories rejected by
+// the remote.
+type rejectedDirs map[string]bool
+
+// contains report whether rel is in a rejected directory.
+func (r rejectedDirs) contains(rel string) bool {
+	for d := path.Dir(rel); d != "." && d != "/"; d = path.Dir(d) {
+		if r[d] {
+			return true
+		}
+	}
+	return r[rel]
+}
+
+// addTo add r to dst with mu locked, if dst is not nil.
+func (r rejectedDirs) addTo(dst rejectedDirs, mu *sync.Mutex) {
+	if dst == nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for d := range r {
+		dst[d] = true
+	}
+}
+
+// opSender send PlanOps of PutFile on a session. The parent directories of
+// each op are sent before it, unless they are already open.
+type opSender struct {
+	c        *source
+	fs       FS                 // OSFS if nil
+	dirs     map[string]*PlanOp // directories by relative path
+	open     []string           // relative paths of the open directories
+	rejected rejectedDirs
+}
+
+// send send op, after moving to its parent directory.
+func (o *opSender) send(op *PlanOp) error {
+	if o.rejected.contains(op.Path) {
+		return nil
+	}
+
+	// close the directories not containing op
+	parent := path.Dir(op.Path)
+	for len(o.open) > 0 && !inDir(o.open[len(o.open)-1], parent) {
+		if err := o.end(); err != nil {
+			return err
+		}
+	}
+
+	// open the parents not open yet
+	var missing []string
+	for d := parent; d != "." && (len(o.open) == 0 || d != o.open[len(o.open)-1]); d = path.Dir(d) {
+		missing = append(missing, d)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if ok, err := o.dir(missing[i]); !ok {
+			return err
+		}
+	}
+
+	if op.Type == TypeDir {
+		_, err := o.dir(op.Path)
+		return err
+	}
+
+	file, err := localFS(o.fs).Open(op.src)
+	if err != nil {
+		return o.c.fail(localError("open", op.src, err))
+	}
+	defer file.Close()
+
+	hdr := op.Header
+	return o.c.file(&hdr, file)
+}
+
+// dir open the directory at rel.
+func (o *opSender) dir(rel string) (ok bool, err error) {
+	hdr := o.dirs[rel].Header
+	if ok, err = o.c.dir(&hdr); ok {
+		o.open = append(o.open, rel)
+	} else {
+		o.rejected[rel] = true
+	}
+	return ok, err
+}
+
+// end close the current directory.
+func (o *opSender) end() error {
+	o.open = o.open[:len(o.open)-1]
+	return o.c.end()
+}
+
+// close close all open directories.
+func (o *opSender) close() error {
+	for len(o.open) > 0 {
+		if err := o.end(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inDir report whether the relative path rel is dir or in it.
+func inDir(dir, rel string) bool {
+	return rel == dir || strings.HasPrefix(rel, dir+"/")
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// planFiles return the plan of PutFile of the files in dir.
+func planFiles(t *testing.T, dir string, fromPaths ...string) (*Plan, map[string]*PlanOp) {
+	for i, p := range fromPaths {
+		fromPaths[i] = filepath.Join(dir, p)
+	}
+	plan, err := (&SCPClient{}).PutFilePlan(context.Background(), fromPaths, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := map[string]*PlanOp{}
+	for i := range plan.Ops {
+		if op := &plan.Ops[i]; op.Type == TypeDir {
+			dirs[op.Path] = op
+		}
+	}
+	return plan, dirs
+}
+
+// planOp return the op of plan at rel.
+func planOp(plan *Plan, rel string) *PlanOp {
+	for i := range plan.Ops {
+		if plan.Ops[i].Path == rel {
+			return &plan.Ops[i]
+		}
+	}
+	return nil
+}
+
+func TestOpSender(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a":          "aaa",
+		"top/sub/b":      "bb",
+		"top/sub/deep/c": "c",
+		"top/other/d":    "dd",
+		"e":              "e",
+	})
+	defer os.RemoveAll(dir)
+	plan, dirs := planFiles(t, dir, "top", "e")
+
+	f := &fakeSink{}
+	err := runFakeSink(f, AbortOnError, func(c *source) error {
+		o := &opSender{c: c, dirs: dirs, rejected: rejectedDirs{}}
+		for _, rel := range []string{"top/sub/b", "top/sub/deep/c", "top/other/d", "e"} {
+			if err := o.send(planOp(plan, rel)); err != nil {
+				return err
+			}
+		}
+		return o.close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"D0755 0 top",
+		"D0755 0 sub",
+		"C0644 2 b",
+		"D0755 0 deep",
+		"C0644 1 c",
+		"E",
+		"E",
+		"D0755 0 other",
+		"C0644 2 d",
+		"E",
+		"E",
+		"C0644 1 e",
+	}
+	if !reflect.DeepEqual(f.lines, want) {
+		t.Errorf("lines = %q, want %q", f.lines, want)
+	}
+	if f.data["b"] != "bb" || f.data["e"] != "e" {
+		t.Errorf("data = %q", f.data)
+	}
+}
+
+func TestOpSenderRejected(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/sub/a": "a",
+		"top/sub/b": "b",
+		"top/c":     "c",
+	})
+	defer os.RemoveAll(dir)
+	plan, dirs := planFiles(t, dir, "top")
+
+	f := &fakeSink{reject: map[string]byte{"sub": respWarning}}
+	var o *opSender
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		o = &opSender{c: c, dirs: dirs, rejected: rejectedDirs{}}
+		for _, rel := range []string{"top/sub/a", "top/sub/b", "top/c"} {
+			if err := o.send(planOp(plan, rel)); err != nil {
+				return err
+			}
+		}
+		return o.close()
+	})
+	if _, ok := err.(*RemoteError); !ok {
+		t.Fatalf("err = %v, want *RemoteError", err)
+	}
+
+	// sub is sent once, and the files in it are skipped
+	want := []string{"D0755 0 top", "D0755 0 sub", "C0644 1 c", "E"}
+	if !reflect.DeepEqual(f.lines, want) {
+		t.Errorf("lines = %q, want %q", f.lines, want)
+	}
+	if !o.rejected.contains("top/sub/a") || o.rejected.contains("top/c") {
+		t.Errorf("rejected = %v", o.rejected)
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	errA, errB, errC := errors.New("a"), errors.New("b"), errors.New("c")
+
+	p := (&SCPClient{ErrorPolicy: ContinueOnError}).newParallel(context.Background(), 3, nil)
+	p.fail(2, errC)
+	p.fail(0, errA)
+
+	err := p.err(nil, errorList{errB})
+	merr, ok := err.(*MultiError)
+	if !ok {
+		t.Fatalf("err = %v, want *MultiError", err)
+	}
+	if want := []error{errB, errA, errC}; !reflect.DeepEqual(merr.Errors, want) {
+		t.Errorf("errors = %v, want %v", merr.Errors, want)
+	}
+
+	// AbortOnError return the error that stopped the sessions, not the
+	// errors caused by the stop
+	p = (&SCPClient{}).newParallel(context.Background(), 3, nil)
+	p.fail(2, errC)
+	p.fail(1, context.Canceled)
+	if err = p.err(nil, nil); err != errC {
+		t.Errorf("err = %v, want %v", err, errC)
+	}
+}
+
+func TestParallelRun(t *testing.T) {
+	p := (&SCPClient{}).newParallel(context.Background(), 10, nil)
+	for i := 0; i < 10; i++ {
+		p.queue(i)
+	}
+
+	// a session is refused, and the others do all files
+	var refused, done int32
+	p.run(3, func() error {
+		if atomic.CompareAndSwapInt32(&refused, 0, 1) {
+			return &ssh.OpenChannelError{Reason: ssh.Prohibited}
+		}
+		for {
+			if _, ok := p.next(); !ok {
+				return nil
+			}
+			atomic.AddInt32(&done, 1)
+		}
+	})
+
+	if err := p.err(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if done != 10 {
+		t.Errorf("%d files done, want 10", done)
+	}
+}
+
+func TestParallelRefused(t *testing.T) {
+	p := (&SCPClient{}).newParallel(context.Background(), 2, nil)
+	p.queue(0, 1)
+
+	// all sessions are refused
+	p.run(2, func() error {
+		return &ssh.OpenChannelError{Reason: ssh.Prohibited}
+	})
+	if _, ok := p.err(nil, nil).(*ssh.OpenChannelError); !ok {
+		t.Error("the refused session is not returned")
+	}
+}
+
+func TestParallelProgress(t *testing.T) {
+	var running, max int32
+	s := &SCPClient{Progress: func(ev ProgressEvent) {
+		n := atomic.AddInt32(&running, 1)
+		if n > atomic.LoadInt32(&max) {
+			atomic.StoreInt32(&max, n)
+		}
+		atomic.AddInt32(&running, -1)
+	}}
+
+	p := s.newParallel(context.Background(), 4, nil)
+	p.queue(0, 1, 2, 3)
+	p.run(4, func() error {
+		for {
+			i, ok := p.next()
+			if !ok {
+				return nil
+			}
+			for n := 0; n < 100; n++ {
+				p.s.Progress(ProgressEvent{Type: ProgressUpdate, Bytes: int64(i)})
+			}
+		}
+	})
+	if max != 1 {
+		t.Errorf("Progress is called by %d goroutines at the same time", max)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+)
+
+// Plan is the list of files and directories a transfer would create, made
+// by DryRun.
+type Plan struct {
+	Ops []PlanOp
+
+	Files int   // number of files
+	Dirs  int   // number of directories
+	Bytes int64 // total size of files
+}
+
+// PlanOp is a file or directory in Plan.
+type PlanOp struct {
+	// Header is the file or directory, with the mode and times to be set.
+	// Times are zero if they are not preserved.
+	Header
+
+	// Path is the relative path in the transfer, same as Path of
+	// ProgressEvent.
+	Path string
+
+	// LocalPath is the local path to be written by GetFile. It is empty for
+	// uploads, because the remote path is decided by the remote.
+	LocalPath string
+
+	src string // local file read by PutFile
+}
+
+// add add the file or directory of hdr at path.
+func (p *Plan) add(path string, hdr *Header) *PlanOp {
+	op := PlanOp{Header: *hdr, Path: path}
+	op.Mode = hdr.Mode.Perm() // as sent in the protocol
+	p.Ops = append(p.Ops, op)
+	if hdr.Type == TypeDir {
+		p.Dirs++
+	} else {
+		p.Files++
+		p.Bytes += hdr.Size
+	}
+	return &p.Ops[len(p.Ops)-1]
+}
+
+// PutFilePlan return the plan of PutFile, without connecting to the remote.
+// The plan is same as DryRun.
+func (s *SCPClient) PutFilePlan(ctx context.Context, fromPaths []string, toPath string) (*Plan, error) {
+	return s.planSource(ctx, s.putFiles(fromPaths))
+}
+
+// GetFilePlan return the plan of GetFile. The data is read from the remote
+// and discarded, and nothing is written to toPath.
+func (s *SCPClient) GetFilePlan(ctx context.Context, fromPaths []string, toPath string) (*Plan, error) {
+	toPath, err := s.localPath(toPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.getFilePlan(ctx, fromPaths, toPath)
+}
+
+// getFilePlan is GetFilePlan with toPath expanded by localPath.
+func (s *SCPClient) getFilePlan(ctx context.Context, fromPaths []string, toPath string) (*Plan, error) {
+	scpCmd, err := s.sourceCommand(fromPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	p := &planner{plan: plan, w: fileWriter{fs: s.FS, path: toPath, perm: s.Permission}}
+	err = s.runSink(ctx, scpCmd, p)
+	return plan, err
+}
+
+// planSource run send with source adding the records to Plan.
+func (s *SCPClient) planSource(ctx context.Context, send func(c *source) error) (*Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	c := &source{policy: s.ErrorPolicy, plan: plan}
+	return plan, c.errs.with(send(c))
+}
+
+// planner add the entries received by sink to Plan, with the local paths
+// and modes of fileWriter.
+type planner struct {
+	plan *Plan
+	w    fileWriter
+	dirs []string // stack of relative paths of directories
+}
+
+func (p *planner) handle(hdr *Header, body io.Reader) error {
+	if hdr.Type == TypeEnd {
+		p.dirs = p.dirs[:len(p.dirs)-1]
+		p.w.dirs = p.w.dirs[:len(p.w.dirs)-1]
+		return nil
+	}
+
+	// mode and times set by fileWriter
+	h := *hdr
+	if !p.w.perm {
+		h.Mode = 0644
+		if h.Type == TypeDir {
+			h.Mode = 0755
+		}
+		h.ModTime, h.AccessTime = time.Time{}, time.Time{}
+	}
+
+	rel := hdr.Name
+	if len(p.dirs) > 0 {
+		rel = path.Join(p.dirs[len(p.dirs)-1], hdr.Name)
+	}
+	op := p.plan.add(rel, &h)
+	op.LocalPath = p.w.target(hdr)
+
+	if hdr.Type == TypeDir {
+		p.dirs = append(p.dirs, rel)
+		p.w.dirs = append(p.w.dirs, &receivedDir{path: op.LocalPath, hdr: hdr})
+	}
+	return nil
+}
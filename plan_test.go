@@ -0,0 +1,121 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// planSummary return the type, path and mode of ops.
+func planSummary(plan *Plan) (ops []string) {
+	for _, op := range plan.Ops {
+		ops = append(ops, op.line()+" "+op.Path+" "+op.LocalPath)
+	}
+	return
+}
+
+func TestPutFilePlan(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a":     "aaa",
+		"top/sub/b": "bb",
+		"c":         "c",
+	})
+	defer os.RemoveAll(dir)
+
+	// no connection is required
+	s := &SCPClient{Permission: true}
+	plan, err := s.PutFilePlan(context.Background(), []string{
+		filepath.Join(dir, "top"),
+		filepath.Join(dir, "c"),
+	}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"D0755 0 top top ",
+		"C0600 3 a top/a ",
+		"D0755 0 sub top/sub ",
+		"C0600 2 b top/sub/b ",
+		"C0600 1 c c ",
+	}
+	if got := planSummary(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("ops = %q, want %q", got, want)
+	}
+	if plan.Files != 3 || plan.Dirs != 2 || plan.Bytes != 6 {
+		t.Errorf("totals = %d files, %d dirs, %d bytes", plan.Files, plan.Dirs, plan.Bytes)
+	}
+	if plan.Ops[1].ModTime.IsZero() {
+		t.Errorf("times are not planned with Permission")
+	}
+
+	// DryRun does not connect
+	s.DryRun = true
+	if err = s.PutFile([]string{filepath.Join(dir, "top")}, "."); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetFilePlan(t *testing.T) {
+	dir := makeTree(t, nil)
+	defer os.RemoveAll(dir)
+
+	f := &fakeSource{msgs: []string{
+		"T1500000000 0 1500000000 0\n",
+		"D0700 0 top\n",
+		fileMsg("a", "aaa"),
+		"D0700 0 sub\n",
+		"C0600 2 b\nbb\x00",
+		"E\n",
+		"E\n",
+	}}
+
+	plan := &Plan{}
+	p := &planner{plan: plan, w: fileWriter{path: dir}}
+	if err := runFakeSource(f, p); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"D0755 0 top top " + filepath.Join(dir, "top"),
+		"C0644 3 a top/a " + filepath.Join(dir, "top/a"),
+		"D0755 0 sub top/sub " + filepath.Join(dir, "top/sub"),
+		"C0644 2 b top/sub/b " + filepath.Join(dir, "top/sub/b"),
+	}
+	if got := planSummary(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("ops = %q, want %q", got, want)
+	}
+	if plan.Files != 2 || plan.Dirs != 2 || plan.Bytes != 5 {
+		t.Errorf("totals = %d files, %d dirs, %d bytes", plan.Files, plan.Dirs, plan.Bytes)
+	}
+	if !plan.Ops[0].ModTime.IsZero() {
+		t.Errorf("times are planned without Permission")
+	}
+
+	// nothing is written
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("dry run created %d entries", len(entries))
+	}
+
+	// with Permission, the modes and times are kept
+	plan = &Plan{}
+	f.acks, f.err = 0, nil
+	p = &planner{plan: plan, w: fileWriter{path: dir, perm: true}}
+	if err := runFakeSource(f, p); err != nil {
+		t.Fatal(err)
+	}
+	if op := plan.Ops[0]; op.Mode != 0700 || !op.ModTime.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("op = %+v, want mode and times of remote", op)
+	}
+}
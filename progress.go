@@ -0,0 +1,159 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ProgressEventType is the type of ProgressEvent.
+type ProgressEventType int
+
+const (
+	// ProgressStart is sent before the data of a file.
+	ProgressStart ProgressEventType = iota
+
+	// ProgressUpdate is sent while the data of a file is transferred.
+	ProgressUpdate
+
+	// ProgressFinish is sent after the data of a file, with the error of the
+	// file if any.
+	ProgressFinish
+
+	// ProgressSkip is sent for a file or directory not transferred, with
+	// the reason in Err.
+	ProgressSkip
+)
+
+func (t ProgressEventType) String() string {
+	switch t {
+	case ProgressStart:
+		return "start"
+	case ProgressUpdate:
+		return "update"
+	case ProgressFinish:
+		return "finish"
+	case ProgressSkip:
+		return "skip"
+	}
+	return fmt.Sprintf("ProgressEventType(%d)", int(t))
+}
+
+// ProgressEvent is the progress of a file in a transfer.
+type ProgressEvent struct {
+	Type ProgressEventType
+
+	// Path is the relative path of the file in the transfer.
+	Path string
+
+	// Size is the size of the file, from the file header.
+	Size int64
+
+	// Bytes is the number of bytes transferred so far.
+	Bytes int64
+
+	// Err is the error of the file. It is set only for ProgressFinish and
+	// ProgressSkip.
+	Err error
+}
+
+// ProgressFunc receive the progress of transfers. The events of a transfer
+// are sent in order from one goroutine, but the function may be called
+// concurrently by the transfers running at the same time.
+type ProgressFunc func(ev ProgressEvent)
+
+// progress send the events of a transfer to fn.
+type progress struct {
+	fn       ProgressFunc
+	interval time.Duration
+}
+
+// progress return the progress of a transfer, or nil if not required.
+func (s *SCPClient) progress() *progress {
+	if s.Progress == nil {
+		return nil
+	}
+	return &progress{fn: s.Progress, interval: s.ProgressInterval}
+}
+
+// file return the progress of the file at path. It is nil if p is nil.
+func (p *progress) file(path string, size int64) *fileProgress {
+	if p == nil {
+		return nil
+	}
+	return &fileProgress{p: p, ev: ProgressEvent{Path: path, Size: size}}
+}
+
+// skip send ProgressSkip of path.
+func (p *progress) skip(path string, err error) {
+	if p != nil {
+		p.fn(ProgressEvent{Type: ProgressSkip, Path: path, Err: err})
+	}
+}
+
+// fileProgress is the progress of a file. All methods do nothing on nil.
+type fileProgress struct {
+	p    *progress
+	ev   ProgressEvent
+	last time.Time // time of the last event
+}
+
+func (f *fileProgress) send(typ ProgressEventType) {
+	f.ev.Type = typ
+	f.last = time.Now()
+	f.p.fn(f.ev)
+}
+
+func (f *fileProgress) start() {
+	if f != nil {
+		f.send(ProgressStart)
+	}
+}
+
+// add count n bytes, and send ProgressUpdate unless it is within the
+// interval from the last event.
+func (f *fileProgress) add(n int) {
+	if f == nil || n <= 0 {
+		return
+	}
+
+	f.ev.Bytes += int64(n)
+	if time.Since(f.last) >= f.p.interval || f.ev.Bytes == f.ev.Size {
+		f.send(ProgressUpdate)
+	}
+}
+
+func (f *fileProgress) finish(err error) {
+	if f != nil {
+		f.ev.Err = err
+		f.send(ProgressFinish)
+	}
+}
+
+// progressReader count the bytes read from r.
+type progressReader struct {
+	r io.Reader
+	f *fileProgress
+}
+
+func (r progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.f.add(n)
+	return
+}
+
+// progressWriter count the bytes written to w.
+type progressWriter struct {
+	w io.Writer
+	f *fileProgress
+}
+
+func (w progressWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.f.add(n)
+	return
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// progressRecorder record the events as strings.
+type progressRecorder struct {
+	events []string
+}
+
+func (r *progressRecorder) progress(interval time.Duration) *progress {
+	return &progress{fn: r.record, interval: interval}
+}
+
+func (r *progressRecorder) record(ev ProgressEvent) {
+	s := fmt.Sprintf("%s %s %d/%d", ev.Type, ev.Path, ev.Bytes, ev.Size)
+	if ev.Err != nil {
+		s += " error"
+	}
+	r.events = append(r.events, s)
+}
+
+func TestProgressSink(t *testing.T) {
+	f := &fakeSource{msgs: []string{
+		"D0755 0 dir\n",
+		fileMsg("a", "aaa"),
+		"C0644 2 b\nbb\x01scp: b: read error\n",
+		"E\n",
+		fileMsg("c", ""),
+	}}
+
+	rec := &progressRecorder{}
+	err := runFakeSourceWith(f, &rawWriter{w: new(bytes.Buffer)}, func(k *sink) {
+		k.progress = rec.progress(0)
+	})
+	if _, ok := err.(*RemoteError); !ok {
+		t.Fatalf("err = %v, want *RemoteError", err)
+	}
+
+	want := []string{
+		"start dir/a 0/3",
+		"update dir/a 3/3",
+		"finish dir/a 3/3",
+		"start dir/b 0/2",
+		"update dir/b 2/2",
+		"finish dir/b 2/2 error",
+		"start c 0/0",
+		"finish c 0/0",
+	}
+	if !reflect.DeepEqual(rec.events, want) {
+		t.Errorf("events = %q, want %q", rec.events, want)
+	}
+}
+
+func TestProgressSource(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a":     "aaa",
+		"top/sub/b": strings.Repeat("b", 100000),
+	})
+	defer os.RemoveAll(dir)
+
+	rec := &progressRecorder{}
+	f := &fakeSink{}
+	err := runFakeSinkProgress(f, AbortOnError, rec.progress(time.Hour), func(c *source) error {
+		return (&pusher{c: c}).push(filepath.Join(dir, "top"), "top")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// updates are throttled, except the last one
+	want := []string{
+		"start top/a 0/3",
+		"update top/a 3/3",
+		"finish top/a 3/3",
+		"start top/sub/b 0/100000",
+		"update top/sub/b 100000/100000",
+		"finish top/sub/b 100000/100000",
+	}
+	if !reflect.DeepEqual(rec.events, want) {
+		t.Errorf("events = %q, want %q", rec.events, want)
+	}
+}
+
+func TestProgressSourceRejected(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "aaa", "b": "bbb"})
+	defer os.RemoveAll(dir)
+
+	// the header of b is rejected, so that no events of b are sent
+	rec := &progressRecorder{}
+	f := &fakeSink{reject: map[string]byte{"b": respWarning}}
+	err := runFakeSinkProgress(f, ContinueOnError, rec.progress(0), func(c *source) error {
+		p := &pusher{c: c}
+		if err := p.pushFileData(filepath.Join(dir, "a"), "a"); err != nil {
+			return err
+		}
+		return p.pushFileData(filepath.Join(dir, "b"), "b")
+	})
+	if _, ok := err.(*RemoteError); !ok {
+		t.Fatalf("err = %v, want *RemoteError", err)
+	}
+
+	want := []string{"start a 0/3", "update a 3/3", "finish a 3/3"}
+	if !reflect.DeepEqual(rec.events, want) {
+		t.Errorf("events = %q, want %q", rec.events, want)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scp protocol response codes.
+const (
+	respOK      = 0x00
+	respWarning = 0x01
+	respFatal   = 0x02
+)
+
+// record is a single control line of the scp protocol.
+type record struct {
+	typ   byte        // 'C', 'D', 'E' or 'T'
+	mode  os.FileMode // C and D
+	size  int64       // C
+	name  string      // C and D
+	mtime time.Time   // T
+	atime time.Time   // T
+}
+
+// parseRecord parse a scp protocol control line (without newline).
+func parseRecord(line string) (rec record, err error) {
+	if len(line) == 0 {
+		return rec, &ProtocolError{Msg: "empty line"}
+	}
+
+	rec.typ = line[0]
+
+	switch rec.typ {
+	case 'E':
+		if len(line) != 1 {
+			return rec, &ProtocolError{Line: line, Msg: "invalid end line"}
+		}
+
+	case 'C', 'D':
+		// e.g. `C0644 1234 name`
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			return rec, &ProtocolError{Line: line, Msg: "invalid header line"}
+		}
+
+		mode, perr := strconv.ParseUint(fields[0], 8, 32)
+		if perr != nil {
+			return rec, &ProtocolError{Line: line, Msg: "invalid mode"}
+		}
+
+		size, perr := strconv.ParseInt(fields[1], 10, 64)
+		if perr != nil || size < 0 {
+			return rec, &ProtocolError{Line: line, Msg: "invalid size"}
+		}
+
+		// "." is the directory sent by OpenSSH scp for `scp -rf .`
+		name := fields[2]
+		if !validName(name) && !(rec.typ == 'D' && name == ".") {
+			return rec, &ProtocolError{Line: line, Msg: "invalid name"}
+		}
+
+		rec.mode = os.FileMode(mode).Perm()
+		rec.size = size
+		rec.name = name
+
+	case 'T':
+		// e.g. `T<mtime> 0 <atime> 0`
+		fields := strings.Split(line[1:], " ")
+		if len(fields) != 4 {
+			return rec, &ProtocolError{Line: line, Msg: "invalid time line"}
+		}
+
+		var t [4]int64
+		for i, f := range fields {
+			t[i], err = strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				return rec, &ProtocolError{Line: line, Msg: "invalid time line"}
+			}
+		}
+		rec.mtime = time.Unix(t[0], t[1]*int64(time.Microsecond))
+		rec.atime = time.Unix(t[2], t[3]*int64(time.Microsecond))
+
+	default:
+		return rec, &ProtocolError{Line: line, Msg: "unknown protocol line"}
+	}
+
+	return rec, nil
+}
+
+// validName report whether name can be sent as the name of a file or a
+// directory. It must be a single path element, without newline.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\n")
+}
+
+// readResponse read a response byte (and message, if error) from the remote scp.
+func readResponse(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	switch b {
+	case respOK:
+		return nil
+	case respWarning, respFatal:
+		msg, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		return &RemoteError{Severity: Severity(b), Message: msg}
+	default:
+		return &ProtocolError{Msg: fmt.Sprintf("unexpected response byte %#x", b)}
+	}
+}
+
+// readLine read a protocol line, and trim newline.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return line, unexpectedEOF(err)
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// unexpectedEOF convert io.EOF in the middle of a message to io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writeError mark the error of the writer in io.Copy.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+// errWriter wrap the errors of w with writeError, to distinguish them from
+// the errors of the reader in io.Copy.
+type errWriter struct {
+	w io.Writer
+}
+
+func (r errWriter) Write(p []byte) (n int, err error) {
+	n, err = r.w.Write(p)
+	if err != nil {
+		err = &writeError{err: err}
+	}
+	return
+}
+
+// zeroReader read infinite null bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
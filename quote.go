@@ -0,0 +1,145 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// isShellSafe report whether s can be passed to POSIX shells without quote.
+func isShellSafe(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("@%+=:,./_-", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// shellQuote quote s as a single word for POSIX shells. Nothing is special
+// between single quotes, even newlines. Single quote in s is replaced with
+// a quote closing the string, an escaped quote and a quote reopening it.
+func shellQuote(s string) string {
+	if isShellSafe(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// quotePath quote remote path for the remote shell. Leading `~` or `~user`
+// is not quoted, so that it is still expanded to the home directory.
+func quotePath(path string) string {
+	if strings.HasPrefix(path, "~") {
+		home, rest := path, ""
+		if i := strings.Index(path, "/"); i >= 0 {
+			home, rest = path[:i], path[i:]
+		}
+
+		// the slash after home must not be quoted, to be expanded
+		if home == "~" || isShellSafe(home[1:]) {
+			if rest == "" || rest == "/" {
+				return home + rest
+			}
+			return home + "/" + shellQuote(rest[1:])
+		}
+	}
+	return shellQuote(path)
+}
+
+// quotePaths quote each path with quotePath, and join them with space.
+func quotePaths(paths []string) string {
+	quoted := make([]string, len(paths))
+	for i, path := range paths {
+		quoted[i] = quotePath(path)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellSplit split the command line s into words, as POSIX shells do with
+// quotes and backslashes. The leading `~` of an unquoted word is replaced
+// with home. Other expansions are not done, and the shell operators are
+// errors.
+func shellSplit(s, home string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return nil, errors.New("scplib: unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+j])
+			i += j + 1
+			inWord = true
+
+		case c == '"':
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, errors.New("scplib: unterminated double quote")
+				}
+				if s[i] == '"' {
+					break
+				}
+				if s[i] == '$' || s[i] == '`' {
+					return nil, fmt.Errorf("scplib: unsupported shell syntax %q", s[i])
+				}
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			inWord = true
+
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, errors.New("scplib: trailing backslash")
+			}
+			i++
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+
+		case strings.IndexByte("|&;<>()$`", c) >= 0:
+			return nil, fmt.Errorf("scplib: unsupported shell syntax %q", c)
+
+		case c == '~' && !inWord && (i+1 == len(s) || strings.IndexByte("/ \t\n", s[i+1]) >= 0):
+			word.WriteString(home)
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var quoteTests = []string{
+	"plain",
+	"/usr/local/file.txt",
+	"",
+	"with space",
+	"a'; rm -rf ~; '",
+	`double"quote`,
+	"$HOME",
+	"$(id)",
+	"`id`",
+	"back\\slash",
+	"new\nline",
+	"-rf",
+	"--",
+	"*.go",
+	"tab\there",
+	"'",
+	"''",
+	"!hist",
+	"semi;colon&amp|pipe>redir<in",
+	"日本語 ファイル",
+}
+
+// shellWords run printf in sh with the words, and return the printed words.
+func shellWords(t *testing.T, words string) []string {
+	out, err := exec.Command("sh", "-c", "printf '%s\\0' "+words).Output()
+	if err != nil {
+		t.Fatalf("sh -c %q: %v", words, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
+
+func TestShellQuote(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	for _, path := range quoteTests {
+		got := shellWords(t, shellQuote(path))
+		if len(got) != 1 || got[0] != path {
+			t.Errorf("shellQuote(%q) = %s, sh read it as %q", path, shellQuote(path), got)
+		}
+	}
+
+	// all in one command line
+	got := shellWords(t, quotePaths(quoteTests))
+	if strings.Join(got, "\x00") != strings.Join(quoteTests, "\x00") {
+		t.Errorf("quotePaths read as %q", got)
+	}
+}
+
+func TestQuotePathHome(t *testing.T) {
+	for path, want := range map[string]string{
+		"~":              "~",
+		"~/file":         "~/file",
+		"~/":             "~/",
+		"~/my file":      "~/'my file'",
+		"~user/a'b":      `~user/'a'\''b'`,
+		"~bad user/file": "'~bad user/file'",
+		"a~b":            "'a~b'",
+	} {
+		if got := quotePath(path); got != want {
+			t.Errorf("quotePath(%q) = %s, want %s", path, got, want)
+		}
+	}
+
+	// the home is expanded by sh
+	if _, err := exec.LookPath("sh"); err == nil {
+		got := shellWords(t, quotePath("~/my file"))
+		if len(got) != 1 || strings.HasPrefix(got[0], "~") || !strings.HasSuffix(got[0], "/my file") {
+			t.Errorf("sh read ~/my file as %q", got)
+		}
+	}
+}
+
+func TestShellSplit(t *testing.T) {
+	// the quoted words are split back
+	for _, s := range quoteTests {
+		words, err := shellSplit("cmd "+shellQuote(s)+" "+quotePath("~/"+s), "HOME")
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if want := []string{"cmd", s, "HOME/" + s}; !reflect.DeepEqual(words, want) {
+			t.Errorf("%q: words = %q, want %q", s, words, want)
+		}
+	}
+
+	tests := map[string][]string{
+		"a  b\tc":          {"a", "b", "c"},
+		`"a b" 'c d'e`:     {"a b", "c de"},
+		`"a\"b\$c\d"`:      {`a"b$c\d`},
+		`a\ b \'`:          {"a b", "'"},
+		`~ ~/a a~ "~/b"`:   {"HOME", "HOME/a", "a~", "~/b"},
+		`~user/a *.txt`:    {"~user/a", "*.txt"},
+		"line\\\ncontinue": {"linecontinue"},
+	}
+	for line, want := range tests {
+		words, err := shellSplit(line, "HOME")
+		if err != nil || !reflect.DeepEqual(words, want) {
+			t.Errorf("%q: words = %q, %v, want %q", line, words, err, want)
+		}
+	}
+
+	for _, line := range []string{`'a`, `"a`, `a\`, `a; b`, `a | b`, `$(id)`, "`id`", `"$HOME"`, `a > b`} {
+		if _, err := shellSplit(line, "HOME"); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
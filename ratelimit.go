@@ -0,0 +1,204 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultBurst is the burst of RateLimiter, when it is not positive.
+const DefaultBurst = 32 * 1024
+
+// RateLimiter limit the bandwidth of transfers, like `scp -l`. It is a
+// token bucket, that is filled limit bytes per second up to burst bytes.
+// A RateLimiter can be shared by many SCPClient, and the limit of all of
+// their transfers is the total. It is safe for concurrent use, and the
+// limit can be changed while transfers are running. The zero value has no
+// limit, and DefaultBurst.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int64 // bytes per second, not positive means no limit
+	burst  int   // not positive means DefaultBurst
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter return RateLimiter of limit bytes per second, with burst
+// bytes. If burst is not positive, DefaultBurst is used.
+func NewRateLimiter(limit int64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	return &RateLimiter{limit: limit, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// burstSize return the burst, or DefaultBurst if not positive. l.mu must
+// be held.
+func (l *RateLimiter) burstSize() int {
+	if l.burst <= 0 {
+		return DefaultBurst
+	}
+	return l.burst
+}
+
+// advance fill the bucket until now. l.mu must be held.
+func (l *RateLimiter) advance(now time.Time) {
+	if l.limit > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.limit)
+		if b := float64(l.burstSize()); l.tokens > b {
+			l.tokens = b
+		}
+	}
+	l.last = now
+}
+
+// Limit return the limit in bytes per second.
+func (l *RateLimiter) Limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit change the limit to bytes per second. Not positive limit means
+// no limit.
+func (l *RateLimiter) SetLimit(limit int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	if l.limit <= 0 {
+		// start with full bucket, not with the debt of unlimited time
+		l.tokens = float64(l.burstSize())
+	}
+	l.limit = limit
+}
+
+// Burst return the burst in bytes.
+func (l *RateLimiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burstSize()
+}
+
+// SetBurst change the burst. If burst is not positive, DefaultBurst is
+// used.
+func (l *RateLimiter) SetBurst(burst int) {
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	l.burst = burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+// WaitN block until n bytes are allowed, or ctx is done. n larger than
+// the burst is allowed, by waiting for the debt.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.advance(now)
+	if l.limit <= 0 {
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / float64(l.limit) * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk return the size of a read or write for p, not to exceed the burst.
+func (l *RateLimiter) chunk(p []byte) []byte {
+	if b := l.Burst(); len(p) > b {
+		return p[:b]
+	}
+	return p
+}
+
+// reader return r limited by l. If l is nil, r is returned.
+func (l *RateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitReader{ctx: ctx, r: r, l: l}
+}
+
+// writer return w limited by l. If l is nil, w is returned.
+func (l *RateLimiter) writer(ctx context.Context, w io.WriteCloser) io.WriteCloser {
+	if l == nil {
+		return w
+	}
+	return &limitWriter{ctx: ctx, w: w, l: l}
+}
+
+// limitReader wait for the bytes read from r.
+type limitReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *RateLimiter
+}
+
+func (r *limitReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(r.l.chunk(p))
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil {
+			// the bytes are already read from r
+			return n, werr
+		}
+	}
+	return
+}
+
+// limitWriter wait before writing bytes to w.
+type limitWriter struct {
+	ctx context.Context
+	w   io.WriteCloser
+	l   *RateLimiter
+}
+
+func (w *limitWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := w.l.chunk(p)
+		if err = w.l.WaitN(w.ctx, len(chunk)); err != nil {
+			return
+		}
+
+		var m int
+		m, err = w.w.Write(chunk)
+		n += m
+		if err != nil {
+			return
+		}
+		p = p[m:]
+	}
+	return
+}
+
+func (w *limitWriter) Close() error {
+	return w.w.Close()
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReader(t *testing.T) {
+	// 10KiB of burst, and 40KiB in 0.4 second
+	l := NewRateLimiter(100*1024, 10*1024)
+	r := l.reader(context.Background(), bytes.NewReader(make([]byte, 50*1024)))
+
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 50*1024 {
+		t.Errorf("copied %d bytes, want %d", n, 50*1024)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("copied in %v, want about 400ms", d)
+	}
+}
+
+func TestRateLimiterWriter(t *testing.T) {
+	l := NewRateLimiter(100*1024, 10*1024)
+	buf := new(bytes.Buffer)
+	w := l.writer(context.Background(), nopWriteCloser{buf})
+
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 50*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 50*1024 {
+		t.Errorf("written %d bytes, want %d", buf.Len(), 50*1024)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("written in %v, want about 400ms", d)
+	}
+}
+
+func TestRateLimiterZero(t *testing.T) {
+	l := &RateLimiter{}
+	if b := l.Burst(); b != DefaultBurst {
+		t.Errorf("burst = %d, want %d", b, DefaultBurst)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		buf := new(bytes.Buffer)
+		_, err := l.writer(context.Background(), nopWriteCloser{buf}).Write(make([]byte, 100*1024))
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, l.reader(context.Background(), buf))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("zero RateLimiter blocks")
+	}
+
+	// limited from the full bucket of DefaultBurst
+	l.SetLimit(DefaultBurst * 10)
+	start := time.Now()
+	if err := l.WaitN(context.Background(), DefaultBurst*2); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("waited %v, want about 100ms", d)
+	}
+}
+
+func TestRateLimiterReaderCanceled(t *testing.T) {
+	l := NewRateLimiter(1024, 1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the bytes read are returned with the error
+	p := make([]byte, 100)
+	n, err := l.reader(ctx, bytes.NewReader(make([]byte, 100))).Read(p)
+	if n != 100 || err != context.Canceled {
+		t.Errorf("n = %d, err = %v, want 100, %v", n, err, context.Canceled)
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	// two transfers of 25KiB share 100KiB/s
+	l := NewRateLimiter(100*1024, 10*1024)
+
+	start := time.Now()
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			io.Copy(ioutil.Discard, l.reader(context.Background(), bytes.NewReader(make([]byte, 25*1024))))
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("copied in %v, want about 400ms", d)
+	}
+}
+
+func TestRateLimiterSetLimit(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// 1 byte per second can not send 1KiB before the deadline
+	if err := l.WaitN(ctx, 1024); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	l.SetLimit(0)
+	if err := l.WaitN(context.Background(), 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if l.Limit() != 0 {
+		t.Errorf("limit = %d, want 0", l.Limit())
+	}
+
+	l.SetBurst(0)
+	if l.Burst() != DefaultBurst {
+		t.Errorf("burst = %d, want %d", l.Burst(), DefaultBurst)
+	}
+}
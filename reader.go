@@ -0,0 +1,192 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+)
+
+// Reader read the entries of scp format data, e.g. the data returned by
+// GetData or GetStream. Next advance to the next entry, and Read read the
+// data of the current file entry.
+type Reader struct {
+	r     *bufio.Reader
+	src   *recordReader     // underlying reader
+	body  *io.LimitedReader // data of current file
+	depth int               // depth of directory
+	err   error             // sticky error
+}
+
+// NewReader return Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	src := &recordReader{r: r}
+	return &Reader{r: bufio.NewReader(src), src: src}
+}
+
+// Next advance to the next entry, and return its Header. The rest of the
+// current file data is skipped. io.EOF is returned at the end of data.
+//
+// A warning message in the data is returned as *RemoteError, and Next can
+// be called again to continue. Other errors are permanent.
+func (tr *Reader) Next() (*Header, error) {
+	if tr.err != nil {
+		return nil, tr.err
+	}
+
+	hdr, err := tr.next()
+	if !tr.warning(err) {
+		tr.err = err
+	}
+	return hdr, err
+}
+
+// warning report whether err is a warning message in the data, that is not
+// permanent.
+func (tr *Reader) warning(err error) bool {
+	rerr, ok := err.(*RemoteError)
+	return ok && rerr.Severity == SeverityWarning && tr.src.err == nil
+}
+
+func (tr *Reader) next() (*Header, error) {
+	// skip the rest of current file, and its status
+	if tr.body != nil {
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return nil, err
+		}
+	}
+
+	var times *record
+	for {
+		b, err := tr.r.ReadByte()
+		if err == io.EOF {
+			if times != nil || tr.depth > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		// warning or fatal message
+		if b == respWarning || b == respFatal {
+			msg, err := readLine(tr.r)
+			if err != nil {
+				return nil, err
+			}
+			return nil, &RemoteError{Severity: Severity(b), Message: msg}
+		}
+
+		tr.r.UnreadByte()
+		line, err := readLine(tr.r)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := parseRecord(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch rec.typ {
+		case 'T':
+			if times != nil {
+				return nil, &ProtocolError{Line: line, Msg: "duplicate time line"}
+			}
+			times = &rec
+			continue
+
+		case 'E':
+			if times != nil {
+				return nil, &ProtocolError{Line: line, Msg: "time line before end line"}
+			}
+			if tr.depth == 0 {
+				return nil, &ProtocolError{Line: line, Msg: "unexpected end line"}
+			}
+			tr.depth--
+
+		case 'D':
+			tr.depth++
+
+		case 'C':
+			tr.body = &io.LimitedReader{R: tr.r, N: rec.size}
+		}
+
+		hdr := newHeader(rec)
+		if times != nil {
+			hdr.ModTime = times.mtime
+			hdr.AccessTime = times.atime
+		}
+		return hdr, nil
+	}
+}
+
+// Read read the data of the current file entry. It returns io.EOF at the
+// end of the data, or if the current entry is not a file.
+//
+// If the sender failed to send the file, a warning message follows the
+// data instead of the null character. It is returned as *RemoteError with
+// the last bytes of the data, and Next can be called again to continue.
+func (tr *Reader) Read(p []byte) (n int, err error) {
+	if tr.err != nil {
+		return 0, tr.err
+	}
+	if tr.body == nil {
+		return 0, io.EOF
+	}
+
+	n, err = tr.body.Read(p)
+	if err == io.EOF && tr.body.N > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == nil || err == io.EOF {
+		if tr.body.N == 0 {
+			err = tr.status()
+		}
+	}
+
+	if err != nil && err != io.EOF && !tr.warning(err) {
+		tr.err = err
+	}
+	return n, err
+}
+
+// status read the status of the current file after its data. It returns
+// io.EOF if the file is complete.
+func (tr *Reader) status() error {
+	tr.body = nil
+
+	b, err := tr.r.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	switch b {
+	case respOK:
+		return io.EOF
+	case respWarning, respFatal:
+		msg, err := readLine(tr.r)
+		if err != nil {
+			return err
+		}
+		return &RemoteError{Severity: Severity(b), Message: msg}
+	}
+	return &ProtocolError{Msg: "missing null character after data"}
+}
+
+// recordReader record the error of the underlying reader of Reader. The
+// error is permanent, even if it is *RemoteError.
+type recordReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *recordReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blacknon/go-scplib"
+)
+
+func ExampleReader() {
+	data := "D0755 0 dir\n" +
+		"C0644 6 hello.txt\nhello\n\x00" +
+		"E\n"
+
+	tr := scplib.NewReader(strings.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		switch hdr.Type {
+		case scplib.TypeDir:
+			fmt.Printf("dir %s %v\n", hdr.Name, hdr.Mode)
+		case scplib.TypeFile:
+			body, _ := ioutil.ReadAll(tr)
+			fmt.Printf("file %s %v %q\n", hdr.Name, hdr.Mode, body)
+		case scplib.TypeEnd:
+			fmt.Println("end")
+		}
+	}
+
+	// Output:
+	// dir dir -rwxr-xr-x
+	// file hello.txt -rw-r--r-- "hello\n"
+	// end
+}
+
+func TestReader(t *testing.T) {
+	data := "T1500000000 0 1500000100 0\n" +
+		"D0700 0 top\n" +
+		"C0600 5 skipped\n12345\x00" +
+		"T1600000000 500000 1600000001 0\n" +
+		"C0644 3 read\nabc\x00" +
+		"C0644 0 empty\n\x00" +
+		"E\n"
+
+	type entry struct {
+		typ   byte
+		name  string
+		mode  uint32
+		size  int64
+		mtime time.Time
+		atime time.Time
+		body  string
+	}
+	want := []entry{
+		{scplib.TypeDir, "top", 0700, 0, time.Unix(1500000000, 0), time.Unix(1500000100, 0), ""},
+		{scplib.TypeFile, "skipped", 0600, 5, time.Time{}, time.Time{}, ""},
+		{scplib.TypeFile, "read", 0644, 3, time.Unix(1600000000, 500000000), time.Unix(1600000001, 0), "abc"},
+		{scplib.TypeFile, "empty", 0644, 0, time.Time{}, time.Time{}, ""},
+		{scplib.TypeEnd, "", 0, 0, time.Time{}, time.Time{}, ""},
+	}
+
+	tr := scplib.NewReader(strings.NewReader(data))
+	for i, w := range want {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+
+		got := entry{hdr.Type, hdr.Name, uint32(hdr.Mode), hdr.Size, hdr.ModTime, hdr.AccessTime, ""}
+		if hdr.Name == "read" {
+			body, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.body = string(body)
+		}
+
+		if !got.mtime.Equal(w.mtime) || !got.atime.Equal(w.atime) {
+			t.Errorf("entry %d: times = %v %v, want %v %v", i, got.mtime, got.atime, w.mtime, w.atime)
+		}
+		got.mtime, got.atime = w.mtime, w.atime
+		if got != w {
+			t.Errorf("entry %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("last Next = %v, want io.EOF", err)
+	}
+}
+
+func TestReaderWarning(t *testing.T) {
+	data := "\x01scp: missing: No such file or directory\n" +
+		"C0644 1 a\na\x00"
+
+	tr := scplib.NewReader(strings.NewReader(data))
+	_, err := tr.Next()
+
+	var rerr *scplib.RemoteError
+	if !errors.As(err, &rerr) || rerr.Severity != scplib.SeverityWarning {
+		t.Fatalf("err = %v, want warning *RemoteError", err)
+	}
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "a" {
+		t.Fatalf("Next after warning = %v, %v", hdr, err)
+	}
+}
+
+func TestReaderBrokenFile(t *testing.T) {
+	data := "C0644 3 a\naa\x00\x01scp: a: read error\n" +
+		"C0644 1 b\nb\x00"
+
+	tr := scplib.NewReader(strings.NewReader(data))
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the warning is returned with the data
+	body, err := ioutil.ReadAll(tr)
+	var rerr *scplib.RemoteError
+	if !errors.As(err, &rerr) || rerr.Message != "scp: a: read error" {
+		t.Fatalf("err = %v, want warning *RemoteError", err)
+	}
+	if string(body) != "aa\x00" {
+		t.Errorf("body = %q", body)
+	}
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "b" {
+		t.Fatalf("Next after broken file = %v, %v", hdr, err)
+	}
+
+	// the warning of the skipped file is returned by Next
+	tr = scplib.NewReader(strings.NewReader(data))
+	tr.Next()
+	if _, err = tr.Next(); !errors.As(err, &rerr) {
+		t.Fatalf("err = %v, want warning *RemoteError", err)
+	}
+	if hdr, err = tr.Next(); err != nil || hdr.Name != "b" {
+		t.Fatalf("Next after broken file = %v, %v", hdr, err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := map[string]string{
+		"garbage line":      "X0644 1 a\n",
+		"bad size":          "C0644 x a\n",
+		"bad mode":          "C9999 1 a\n",
+		"slash in name":     "C0644 1 ../a\na\x00",
+		"unbalanced end":    "E\n",
+		"missing null":      "C0644 1 a\naX",
+		"truncated data":    "C0644 10 a\nabc",
+		"unterminated dir":  "D0755 0 a\n",
+		"unterminated line": "C0644 1 a",
+		"time before end":   "D0755 0 a\nT1 0 1 0\nE\n",
+	}
+
+	for name, data := range tests {
+		tr := scplib.NewReader(strings.NewReader(data))
+
+		var err error
+		for err == nil {
+			_, err = tr.Next()
+		}
+		if err == io.EOF {
+			t.Errorf("%s: no error", name)
+			continue
+		}
+
+		var perr *scplib.ProtocolError
+		if !errors.As(err, &perr) && err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: err = %v, want *ProtocolError or io.ErrUnexpectedEOF", name, err)
+		}
+
+		// the error is permanent
+		if _, err2 := tr.Next(); err2 != err {
+			t.Errorf("%s: error is not sticky: %v", name, err2)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// resumeAlgorithm return the algorithm to verify the prefix of files.
+func (s *SCPClient) resumeAlgorithm() *HashAlgorithm {
+	if s.Verify != nil {
+		return s.Verify
+	}
+	return SHA256
+}
+
+// remoteSize return the size of the remote file.
+func (s *SCPClient) remoteSize(ctx context.Context, remote string) (int64, error) {
+	cmd, err := s.remoteCommand([]string{"wc", "-c"}, []string{remote})
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := s.output(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("scplib: unexpected output of wc: %q", out)
+	}
+	return strconv.ParseInt(fields[0], 10, 64)
+}
+
+// remotePrefixSum return the checksum in hex of the first n bytes of the
+// remote file.
+func (s *SCPClient) remotePrefixSum(ctx context.Context, alg *HashAlgorithm, remote string, n int64) (string, error) {
+	head, err := s.remoteCommand([]string{"head", "-c", strconv.FormatInt(n, 10)}, []string{remote})
+	if err != nil {
+		return "", err
+	}
+
+	for _, command := range alg.Commands {
+		args := make([]string, len(command))
+		for i, arg := range command {
+			args[i] = shellQuote(arg)
+		}
+
+		// the exit status of pipeline is of the checksum command
+		out, err := s.output(ctx, head+" | "+strings.Join(args, " "))
+		if exitStatus(err) == 127 {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			return "", fmt.Errorf("scplib: unexpected output of %s: %q", command[0], out)
+		}
+		return strings.ToLower(strings.TrimPrefix(fields[0], "\\")), nil
+	}
+	return "", fmt.Errorf("scplib: no remote command of %s", alg.Name)
+}
+
+// localPrefixSum return the checksum in hex of the first n bytes of the
+// local file.
+func localPrefixSum(fsys FS, alg *HashAlgorithm, local string, n int64) (string, error) {
+	file, err := fsys.Open(local)
+	if err != nil {
+		return "", localError("open", local, err)
+	}
+	defer file.Close()
+
+	h := alg.New()
+	if _, err = io.CopyN(h, file, n); err != nil {
+		return "", localError("read", local, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resumable report whether the destination of n bytes is the prefix of
+// the source of size bytes, by the checksums of the local and remote files.
+func (s *SCPClient) resumable(ctx context.Context, local, remote string, n, size int64) (bool, error) {
+	if n <= 0 || n > size {
+		return false, nil
+	}
+
+	alg := s.resumeAlgorithm()
+	localSum, err := localPrefixSum(s.fsys(), alg, local, n)
+	if err != nil {
+		return false, err
+	}
+	remoteSum, err := s.remotePrefixSum(ctx, alg, remote, n)
+	if err != nil {
+		return false, err
+	}
+	return localSum == remoteSum, nil
+}
+
+// resumeGetFiles continue the downloads of the remote regular files in
+// fromPaths to the partial local files, and return the paths not resumed.
+// The files are not resumed, if FS is not AppendFS.
+func (s *SCPClient) resumeGetFiles(ctx context.Context, fromPaths []string, toPath string, v *verifier) (rest []string, err error) {
+	fsys, ok := s.fsys().(AppendFS)
+	if !ok {
+		return fromPaths, nil
+	}
+
+	for _, from := range fromPaths {
+		name := path.Base(path.Clean(from))
+		local := (&fileWriter{fs: fsys, path: toPath}).target(&Header{Type: TypeFile, Name: name})
+
+		info, err := statFile(fsys, local)
+		if err != nil || !info.Mode().IsRegular() || strings.ContainsAny(from, "*?[") {
+			rest = append(rest, from)
+			continue
+		}
+
+		size, err := s.remoteSize(ctx, from)
+		if err != nil {
+			rest = append(rest, from)
+			continue
+		}
+
+		ok, err := s.resumable(ctx, local, from, info.Size(), size)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			rest = append(rest, from)
+			continue
+		}
+
+		if err = s.resumeGet(ctx, fsys, from, local, name, info.Size(), size); err != nil {
+			return nil, err
+		}
+		if err = addLocalSum(fsys, v, local, name); err != nil {
+			return nil, err
+		}
+	}
+	return rest, nil
+}
+
+// resumeGet append the remote file after n bytes to the local file.
+func (s *SCPClient) resumeGet(ctx context.Context, fsys AppendFS, remote, local, rel string, n, size int64) error {
+	cmd, err := s.remoteCommand([]string{"tail", "-c", "+" + strconv.FormatInt(n+1, 10)}, []string{remote})
+	if err != nil {
+		return err
+	}
+
+	file, err := fsys.Append(local)
+	if err != nil {
+		return localError("open", local, err)
+	}
+	defer file.Close()
+
+	fp := s.progress().file(rel, size)
+	if fp != nil {
+		fp.ev.Bytes = n
+	}
+	fp.start()
+
+	err = s.run(ctx, cmd, func(r io.Reader, w io.Writer) error {
+		m, err := io.Copy(errWriter{w: file}, progressReader{r: r, f: fp})
+		if werr, ok := err.(*writeError); ok {
+			return localError("write", local, werr.err)
+		} else if err != nil {
+			return err
+		}
+		if n+m != size {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err == nil {
+		err = localError("close", local, file.Close())
+	}
+	fp.finish(err)
+	return err
+}
+
+// resumePutFiles continue the uploads of the local regular files in
+// fromPaths to the partial remote files, and return the paths not resumed.
+func (s *SCPClient) resumePutFiles(ctx context.Context, fromPaths []string, remotePath func(rel string) string, v *verifier) (rest []string, err error) {
+	for _, from := range fromPaths {
+		local, err := s.localPath(from)
+		if err != nil {
+			rest = append(rest, from)
+			continue
+		}
+
+		info, err := s.fsys().Lstat(local)
+		if err != nil || !info.Mode().IsRegular() {
+			rest = append(rest, from)
+			continue
+		}
+
+		// same name as PutFile
+		name := filepath.Base(local)
+		remote := remotePath(name)
+
+		n, err := s.remoteSize(ctx, remote)
+		if err != nil {
+			rest = append(rest, from)
+			continue
+		}
+
+		ok, err := s.resumable(ctx, local, remote, n, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			rest = append(rest, from)
+			continue
+		}
+
+		if err = s.resumePut(ctx, local, remote, name, n, info.Size()); err != nil {
+			return nil, err
+		}
+		if err = addLocalSum(s.fsys(), v, local, name); err != nil {
+			return nil, err
+		}
+	}
+	return rest, nil
+}
+
+// resumePut append the local file after n bytes to the remote file.
+func (s *SCPClient) resumePut(ctx context.Context, local, remote, rel string, n, size int64) error {
+	cmd, err := s.remoteCommand([]string{"tee", "-a"}, []string{remote})
+	if err != nil {
+		return err
+	}
+
+	file, err := s.fsys().Open(local)
+	if err != nil {
+		return localError("open", local, err)
+	}
+	defer file.Close()
+
+	// the files not seekable are read from the beginning
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(n, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, file, n)
+	}
+	if err != nil {
+		return localError("seek", local, err)
+	}
+
+	fp := s.progress().file(rel, size)
+	if fp != nil {
+		fp.ev.Bytes = n
+	}
+	fp.start()
+
+	err = s.run(ctx, cmd+" >/dev/null", func(r io.Reader, w io.Writer) error {
+		m, err := io.Copy(errWriter{w: progressWriter{w: w, f: fp}}, file)
+		if werr, ok := err.(*writeError); ok {
+			return werr.err
+		} else if err != nil {
+			return localError("read", local, err)
+		}
+		if n+m != size {
+			return localError("read", local, io.ErrUnexpectedEOF)
+		}
+		return nil
+	})
+
+	// the remote file must be complete
+	if err == nil {
+		var got int64
+		if got, err = s.remoteSize(ctx, remote); err == nil && got != size {
+			err = fmt.Errorf("scplib: size of resumed %s is %d, want %d", remote, got, size)
+		}
+	}
+	fp.finish(err)
+	return err
+}
+
+// addLocalSum add the checksum of the whole local file to v.
+func addLocalSum(fsys FS, v *verifier, local, rel string) error {
+	if v == nil {
+		return nil
+	}
+
+	file, err := fsys.Open(local)
+	if err != nil {
+		return localError("open", local, err)
+	}
+	defer file.Close()
+
+	h := v.hash()
+	if _, err = io.Copy(h, file); err != nil {
+		return localError("read", local, err)
+	}
+	v.add(rel, h)
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalPrefixSum(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "abcdef"})
+	defer os.RemoveAll(dir)
+
+	got, err := localPrefixSum(OSFS{}, SHA256, filepath.Join(dir, "a"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256Hex("abc"); got != want {
+		t.Errorf("sum = %s, want %s", got, want)
+	}
+
+	// the file shorter than the prefix
+	if _, err = localPrefixSum(OSFS{}, SHA256, filepath.Join(dir, "a"), 10); err == nil {
+		t.Error("no error for the short file")
+	}
+}
+
+func TestResumableSize(t *testing.T) {
+	// the sizes not resumable are decided without remote commands
+	s := &SCPClient{}
+	for _, n := range []int64{0, 11} {
+		ok, err := s.resumable(context.Background(), "", "", n, 10)
+		if ok || err != nil {
+			t.Errorf("resumable(%d, 10) = %v, %v, want false, nil", n, ok, err)
+		}
+	}
+}
+
+func TestAddLocalSum(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "abcdef"})
+	defer os.RemoveAll(dir)
+
+	if err := addLocalSum(OSFS{}, nil, filepath.Join(dir, "a"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &verifier{alg: SHA256}
+	if err := addLocalSum(OSFS{}, v, filepath.Join(dir, "a"), "a"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": sha256Hex("abcdef")}
+	if got := verifiedSums(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("sums = %q, want %q", got, want)
+	}
+}
@@ -8,354 +8,403 @@ Package scplib is a library for exchanging data with scp in golang.
 package scplib
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
-	"io"
-
+	"context"
 	"os"
 	"os/user"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
-// TODO(blacknon): bufferを可能な限り使わないようにして、io.Pipeなどを利用したReader/Writerでの対応を主流とする
-
 // SCPClient save credentials and use scp from method.
 type SCPClient struct {
 	Connection *ssh.Client
 	Session    *ssh.Session
 	Permission bool
+
+	// ErrorPolicy decide whether PutFile and PutData abort or continue,
+	// when a file can not be read or is rejected by the remote.
+	ErrorPolicy ErrorPolicy
+
+	// SCPPath is the path of scp program on remote. If empty, DefaultSCPPath
+	// is used. Set "scp" to find it from PATH of remote.
+	SCPPath string
+
+	// Flags is the extra flags passed to remote scp.
+	Flags []string
+
+	// Wrapper is the command that run remote scp, e.g. []string{"sudo", "-n"}.
+	Wrapper []string
+
+	// Env is the environment variables of remote scp, in the form
+	// "KEY=value". They are set as the prefix of the command.
+	Env []string
+
+	// IdleTimeout stop the transfer with ErrIdleTimeout, when no bytes are
+	// sent or received for the duration. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// Progress receive the progress of each file in the transfers, if set.
+	Progress ProgressFunc
+
+	// ProgressInterval is the minimum interval of ProgressUpdate events of a
+	// file. Zero means every update is sent.
+	ProgressInterval time.Duration
+
+	// RateLimit limit the bandwidth of the transfers, if set. The same
+	// RateLimiter can be set to many SCPClient, to limit the total of them.
+	RateLimit *RateLimiter
+
+	// SymlinkPolicy decide how PutFile handle symbolic links, in fromPaths
+	// and in the directories.
+	SymlinkPolicy SymlinkPolicy
+
+	// Filter select the files and directories of the transfers, if set.
+	// The excluded remote entries are read and discarded.
+	Filter *Filter
+
+	// DryRun make PutFile, PutData and GetFile plan the transfer, without
+	// modifying the destination. Uploads do not connect to the remote.
+	// Use PutFilePlan and GetFilePlan to get the plan.
+	DryRun bool
+
+	// Atomic make GetFile write each file to a temporary file in the same
+	// directory, and rename it to the destination after the whole file is
+	// received. The destination is not changed by the failed files.
+	Atomic bool
+
+	// Verify compare the checksums of the local and remote files by the
+	// algorithm, after GetFile and PutFile. The remote checksums are
+	// computed by the remote commands with a new session, so Connection is
+	// required. The mismatches are returned as *ChecksumError.
+	Verify *HashAlgorithm
+
+	// Resume make GetFile and PutFile continue the regular files in
+	// fromPaths, if a partial file exists at the destination. The existing
+	// prefix is verified by the checksum of Verify (SHA256 if nil) with
+	// remote commands, and only the remainder is transferred by tail or
+	// tee -a on the remote. The other files are transferred by scp from the
+	// beginning. Connection is required, and the modes and times of the
+	// resumed files are not preserved.
+	Resume bool
+
+	// Sessions is the number of sessions on Connection, that GetFile and
+	// PutFile run at the same time. If it is more than 1, GetFile receive
+	// each path in fromPaths on a session, and PutFile create the
+	// directories first and then send the files on the sessions, if toPath
+	// is a remote directory. The sessions refused by the server (e.g. over
+	// MaxSessions) are not used. The errors are returned in the order of
+	// the files, and Progress is not called concurrently.
+	Sessions int
+
+	// FS is the local file system, that GetFile write to and PutFile read
+	// from. If nil, OSFS is used. The local paths are expanded (`~` and
+	// relative paths) only for OSFS, and passed to the other FS as is.
+	FS FS
 }
 
-func unset(s []string, i int) []string {
-	if i >= len(s) {
-		return s
+// getFullPath return the absolute path of path. The leading `~` is
+// expanded to the home directory, and the trailing separator is kept.
+func getFullPath(path string) (string, error) {
+	fullPath := path
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		fullPath = usr.HomeDir + path[1:]
+	}
+
+	fullPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		fullPath = strings.TrimSuffix(fullPath, string(filepath.Separator)) + string(filepath.Separator)
 	}
-	return append(s[:i], s[i+1:]...)
+	return fullPath, nil
 }
 
-func getFullPath(path string) (fullPath string) {
-	usr, _ := user.Current()
-	fullPath = strings.Replace(path, "~", usr.HomeDir, 1)
-	fullPath, _ = filepath.Abs(fullPath)
-	return fullPath
+// fsys return the local file system of s.
+func (s *SCPClient) fsys() FS {
+	return localFS(s.FS)
 }
 
-// TODO(blacknon): walker用の関数もあるので、こういうのを使うほうがいい？？
-//                 https://godoc.org/github.com/kr/fs
-func walkDir(dir string) (files []string, err error) {
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			path = path + "/"
-		}
-		files = append(files, path)
-		return nil
-	})
-	return
+// localPath return the local path to read or write, expanded by
+// getFullPath for OSFS.
+func (s *SCPClient) localPath(path string) (string, error) {
+	if _, ok := s.fsys().(OSFS); ok {
+		return getFullPath(path)
+	}
+	return path, nil
 }
 
-// pushDirData is Write directory data to remote.
-func pushDirData(w io.WriteCloser, baseDir string, paths []string, toName string, perm bool) {
-	baseDirSlice := strings.Split(baseDir, "/")
-	baseDirSlice = unset(baseDirSlice, len(baseDirSlice)-1)
-	baseDir = strings.Join(baseDirSlice, "/")
-
-	for _, path := range paths {
-		relPath, _ := filepath.Rel(baseDir, path)
-		dir := filepath.Dir(relPath)
-
-		if len(dir) > 0 && dir != "." {
-			dirList := strings.Split(dir, "/")
-			dirpath := baseDir
-			for _, dirName := range dirList {
-				dirpath = dirpath + "/" + dirName
-				dInfo, _ := os.Lstat(dirpath)
-				dPerm := fmt.Sprintf("%04o", dInfo.Mode().Perm())
-
-				// push directory information
-				fmt.Fprintln(w, "D"+dPerm, 0, dirName)
-			}
-		}
+// pusher push local files and directories with source.
+type pusher struct {
+	c       *source
+	fs      FS // OSFS if nil
+	perm    bool
+	symlink SymlinkPolicy
+	filter  *Filter
 
-		fInfo, _ := os.Lstat(path)
+	root    string        // resolved path of the top-level argument
+	parents []os.FileInfo // stack of pushed directories, to detect loops
+}
 
-		if !fInfo.IsDir() {
-			// check symlink
-			if fInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
-				fmt.Fprintf(os.Stderr, "'%v' is Symlink, Do not copy.\n", path)
-			} else {
-				pushFileData(w, []string{path}, toName, perm)
-			}
-		}
+// push send the file or directory at path as name. Symbolic links are
+// handled by the SymlinkPolicy.
+func (p *pusher) push(path, name string) error {
+	info, err := p.fsys().Lstat(path)
+	if err != nil {
+		return p.c.fail(localError("stat", path, err))
+	}
 
-		if len(dir) > 0 && dir != "." {
-			dirList := strings.Split(dir, "/")
-			endStr := strings.Repeat("E\n", len(dirList))
-			fmt.Fprintf(w, endStr)
+	if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+		if info, err = p.follow(path, name); info == nil {
+			return err
 		}
 	}
-	return
-}
 
-// pushFileData is exchange local file data, to scp format
-func pushFileData(w io.WriteCloser, paths []string, toName string, perm bool) {
-	for _, path := range paths {
-		fInfo, _ := os.Lstat(path)
+	if !p.filter.Match(p.c.path(name), info.IsDir()) {
+		return nil
+	}
 
-		content, err := os.Open(path)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			continue
-		}
+	if info.IsDir() {
+		return p.pushDirData(path, name, info)
+	}
+	return p.pushFileData(path, name)
+}
 
-		stat, _ := content.Stat()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			continue
-		}
+// fsys return the file system of p.
+func (p *pusher) fsys() FS {
+	return localFS(p.fs)
+}
 
-		// default permission(0644)
-		fPerm := "0644"
-		if perm == true {
-			fPerm = fmt.Sprintf("%04o", fInfo.Mode())
+// pushDirData is Write directory data to remote.
+func (p *pusher) pushDirData(dir, name string, dInfo os.FileInfo) (err error) {
+	// a followed link to the parent directory
+	for _, parent := range p.parents {
+		if os.SameFile(parent, dInfo) {
+			return p.c.fail(localError("stat", dir, ErrSymlinkLoop))
 		}
-
-		// push file information
-		fmt.Fprintln(w, "C"+fPerm, stat.Size(), toName)
-		io.Copy(w, content)
-		fmt.Fprint(w, "\x00")
 	}
-	return
-}
 
-// writeData is write to local file, from scp data.
-// TODO(blacknon): Bufferで処理すると、どうしても速度が遅くなったりするので対策を考える
-func writeData(data *bufio.Reader, path string, perm bool) {
-	pwd := path
-checkloop:
-	for {
-		// Get file or dir information (1st line)
-		line, err := data.ReadString('\n')
-
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Println(err)
-		}
+	// push directory information
+	hdr := &Header{Type: TypeDir, Mode: dInfo.Mode(), Name: name}
+	if p.perm == true {
+		hdr.ModTime, hdr.AccessTime = dInfo.ModTime(), fileAtime(dInfo)
+	}
 
-		line = strings.TrimRight(line, "\n")
-		if line == "E" {
-			pwdArray := strings.Split(pwd, "/")
-			if len(pwdArray) > 0 {
-				pwdArray = pwdArray[:len(pwdArray)-2]
-			}
-			pwd = strings.Join(pwdArray, "/") + "/"
-			continue
-		}
+	ok, err := p.c.dir(hdr)
+	if !ok {
+		return err
+	}
 
-		lineSlice := strings.SplitN(line, " ", 3)
-
-		scpType := lineSlice[0][:1]
-		scpPerm := lineSlice[0][1:]
-		scpPerm32, _ := strconv.ParseUint(scpPerm, 8, 32)
-		scpSize, _ := strconv.Atoi(lineSlice[1])
-		scpObjName := lineSlice[2]
-
-		switch {
-		case scpType == "C":
-			scpPath := path
-			// Check pwd
-			check, _ := regexp.MatchString("/$", pwd)
-			if check || pwd != path {
-				scpPath = pwd + scpObjName
-			}
+	p.parents = append(p.parents, dInfo)
+	defer func() { p.parents = p.parents[:len(p.parents)-1] }()
 
-			// set permission
-			if perm == false {
-				scpPerm32, _ = strconv.ParseUint("0644", 8, 32)
-			}
+	entries, err := p.fsys().ReadDir(dir)
+	if err != nil {
+		if err = p.c.fail(localError("readdir", dir, err)); err != nil {
+			return err
+		}
+	}
 
-			// 1st write to file
-			file, err := os.OpenFile(scpPath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, os.FileMode(uint32(scpPerm32)))
-			if err != nil {
-				fmt.Println(err)
-				break checkloop
-			}
-			file.WriteString("")
-			file.Close()
-
-			fileData := []byte{}
-			readedSize := 0
-			remainingSize := scpSize
-
-			outFile, _ := os.OpenFile(scpPath, os.O_WRONLY|os.O_APPEND, os.FileMode(uint32(scpPerm32)))
-			for {
-				readBuffer := make([]byte, remainingSize)
-				readSize, _ := data.Read(readBuffer)
-
-				remainingSize -= readSize
-				readedSize += readSize
-
-				// check readedSize
-				if readSize == 0 {
-					break
-				}
-
-				readBuffer = readBuffer[:readSize]
-				fileData = append(fileData, readBuffer...)
-
-				if readedSize == scpSize {
-					outFile.Write(fileData)
-					fileData = []byte{}
-					break
-				} else if len(fileData) >= 10485760 {
-					// write file over 10MB
-					outFile.Write(fileData)
-					fileData = []byte{}
-				}
-			}
+	for _, fInfo := range entries {
+		if err = p.push(filepath.Join(dir, fInfo.Name()), fInfo.Name()); err != nil {
+			return err
+		}
+	}
 
-			// write file to path
-			os.Chmod(scpPath, os.FileMode(uint32(scpPerm32)))
+	return p.c.end()
+}
 
-			// read last nUll character
-			_, _ = data.ReadByte()
-		case scpType == "D":
-			// Check pwd
-			check, _ := regexp.MatchString("/$", pwd)
-			if !check {
-				pwd = pwd + "/"
-			}
+// pushFileData is exchange local file data, to scp format
+func (p *pusher) pushFileData(path string, toName string) (err error) {
+	content, err := p.fsys().Open(path)
+	if err != nil {
+		return p.c.fail(localError("open", path, err))
+	}
+	defer content.Close()
 
-			if perm == false {
-				scpPerm32, _ = strconv.ParseUint("0755", 8, 32)
-			}
+	var stat os.FileInfo
+	if f, ok := content.(interface{ Stat() (os.FileInfo, error) }); ok {
+		stat, err = f.Stat()
+	} else {
+		stat, err = statFile(p.fsys(), path)
+	}
+	if err != nil {
+		return p.c.fail(localError("stat", path, err))
+	}
 
-			pwd = pwd + scpObjName + "/"
-			err := os.Mkdir(pwd, os.FileMode(uint32(scpPerm32)))
-			if err != nil {
-				fmt.Println(err)
-				os.Chmod(pwd, os.FileMode(uint32(scpPerm32)))
-			}
-		default:
-			fmt.Fprintln(os.Stderr, line)
-			continue checkloop
-			// break checkloop
-		}
+	// default permission(0644)
+	hdr := &Header{Type: TypeFile, Mode: 0644, Size: stat.Size(), Name: toName}
+	if p.perm == true {
+		hdr.Mode = stat.Mode().Perm()
+		hdr.ModTime, hdr.AccessTime = stat.ModTime(), fileAtime(stat)
 	}
-	return
+
+	// push file information
+	return p.c.file(hdr, content)
 }
 
 // GetFile get file data to file (remote to Local).
+// As OpenSSH scp, the entries are put into toPath if it is an existing
+// directory, and are written as toPath otherwise. If there are many
+// fromPaths, toPath must be a directory.
 //
 // example:
 //    scp.GetFile("/From/Remote/Path","/To/Local/Path")
 func (s *SCPClient) GetFile(fromPaths []string, toPath string) (err error) {
-	session := s.Session
-	if s.Connection != nil {
-		session, err = s.Connection.NewSession()
-		if err != nil {
-			return
-		}
-	}
-	defer session.Close()
-
-	fin := make(chan bool)
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
+	return s.GetFileContext(context.Background(), fromPaths, toPath)
+}
 
-		// Null Characters(10,000 char)
-		nc := strings.Repeat("\x00", 100000)
-		fmt.Fprintf(w, nc)
-	}()
+// GetFileContext is GetFile with ctx. If ctx is done before the end, the
+// transfer is stopped, the partial file is removed, and ctx.Err() is returned.
+func (s *SCPClient) GetFileContext(ctx context.Context, fromPaths []string, toPath string) (err error) {
+	if toPath, err = s.localPath(toPath); err != nil {
+		return err
+	}
+	if err = s.checkTargetDir(fromPaths, toPath); err != nil {
+		return err
+	}
+	if s.DryRun {
+		_, err = s.getFilePlan(ctx, fromPaths, toPath)
+		return err
+	}
 
-	go func() {
-		r, _ := session.StdoutPipe()
-		b := bufio.NewReader(r)
-		writeData(b, toPath, s.Permission)
+	v := s.verifier()
+	rest := fromPaths
+	if s.Resume {
+		if rest, err = s.resumeGetFiles(ctx, fromPaths, toPath, v); err != nil {
+			return err
+		}
+	}
 
-		fin <- true
-	}()
+	if len(rest) > 0 {
+		if s.Sessions > 1 && len(rest) > 1 {
+			err = s.getParallel(ctx, rest, toPath, v)
+		} else {
+			err = s.getFiles(ctx, rest, toPath, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.verifyRemote(ctx, v, sourcePath(fromPaths))
+}
 
-	// Create scp command
-	fromPathList := []string{}
-	for _, fromPath := range fromPaths {
-		fromPathList = append(fromPathList, fromPath)
+// checkTargetDir check that the local toPath is a directory, if there are
+// many fromPaths, as OpenSSH scp.
+func (s *SCPClient) checkTargetDir(fromPaths []string, toPath string) error {
+	if len(fromPaths) < 2 {
+		return nil
+	}
+	info, err := statFile(s.fsys(), toPath)
+	if err == nil && !info.IsDir() {
+		err = syscall.ENOTDIR
 	}
-	fromPathString := strings.Join(fromPathList, " ")
-	// TODO(blacknon): scpしてる時点でセキュリティもクソもないのだが、OS Command Injectionへの対策を考える
-	scpCmd := "/usr/bin/scp -rf " + fromPathString
+	return localError("stat", toPath, err)
+}
 
-	// Run scp
-	err = session.Run(scpCmd)
+// getFiles receive fromPaths to toPath on a session.
+func (s *SCPClient) getFiles(ctx context.Context, fromPaths []string, toPath string, v *verifier) error {
+	scpCmd, err := s.sourceCommand(fromPaths)
+	if err != nil {
+		return err
+	}
 
-	<-fin
-	return
+	var h sinkHandler = &fileWriter{fs: s.FS, path: toPath, perm: s.Permission, atomic: s.Atomic}
+	if v != nil {
+		h = &verifyHandler{sinkHandler: h, v: v}
+	}
+	return s.runSink(ctx, scpCmd, h)
 }
 
 // PutFile is put file to remote path.
+// The remote path is decided in the same way as GetFile.
 //
 // example:
 //    scp.PutFile("/From/Local/Path","/To/Remote/Path")
 func (s *SCPClient) PutFile(fromPaths []string, toPath string) (err error) {
-	session := s.Session
-	if s.Connection != nil {
-		session, _ = s.Connection.NewSession()
+	return s.PutFileContext(context.Background(), fromPaths, toPath)
+}
+
+// PutFileContext is PutFile with ctx. If ctx is done before the end, the
+// transfer is stopped, and ctx.Err() is returned.
+func (s *SCPClient) PutFileContext(ctx context.Context, fromPaths []string, toPath string) (err error) {
+	// as OpenSSH scp, many sources must be put into a directory
+	scpCmd, err := s.sinkCommand(toPath, len(fromPaths) > 1)
+	if err != nil {
+		return err
+	}
+
+	// the remote paths of files are decided before the transfer
+	v := s.verifier()
+	var remotePath func(rel string) string
+	if v != nil || (s.Resume && !s.DryRun) {
+		if remotePath, err = s.sinkPath(ctx, toPath); err != nil {
+			return err
+		}
+	}
+
+	rest := fromPaths
+	if s.Resume && !s.DryRun {
+		if rest, err = s.resumePutFiles(ctx, fromPaths, remotePath, v); err != nil {
+			return err
+		}
+	}
+
+	if len(rest) > 0 {
+		if s.Sessions > 1 && !s.DryRun {
+			err = s.putParallel(ctx, scpCmd, rest, toPath, v)
+		} else {
+			err = s.runSource(ctx, scpCmd, s.putVerified(rest, v))
+		}
 		if err != nil {
-			return
+			return err
 		}
 	}
-	defer session.Close()
+	return s.verifyRemote(ctx, v, remotePath)
+}
 
-	// Read Dir or File
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
+// putVerified return the function to send fromPaths, with the checksums
+// added to v.
+func (s *SCPClient) putVerified(fromPaths []string, v *verifier) func(c *source) error {
+	send := s.putFiles(fromPaths)
+	return func(c *source) error {
+		c.verify = v
+		return send(c)
+	}
+}
 
-		for _, fromPath := range fromPaths {
+// putFiles return the function to send fromPaths, for runSource.
+func (s *SCPClient) putFiles(fromPaths []string) func(c *source) error {
+	// Read Dir or File
+	return func(c *source) error {
+		p := &pusher{c: c, fs: s.FS, perm: s.Permission, symlink: s.SymlinkPolicy, filter: s.Filter}
+		for _, from := range fromPaths {
 			// Get full path
-			fromPath = getFullPath(fromPath)
-
-			// File or Dir exits check
-			pInfo, err := os.Lstat(fromPath)
+			fromPath, err := s.localPath(from)
 			if err != nil {
-				return
+				return err
 			}
 
-			if pInfo.IsDir() {
-				// Directory
-				pList, _ := walkDir(fromPath)
-				for _, i := range pList {
-					pushDirData(w, fromPath, []string{i}, filepath.Base(i), s.Permission)
-				}
-			} else {
-				// single files
-				toFile := filepath.Base(toPath)
-				if toFile == "." {
-					toFile = filepath.Base(fromPath)
-				}
-				pushFileData(w, []string{fromPath}, toFile, s.Permission)
+			// The entries keep their names, and the remote decide whether
+			// they are put into toPath or as toPath, as OpenSSH scp.
+			toName := filepath.Base(fromPath)
+
+			p.root, _ = evalSymlinks(p.fsys(), fromPath)
+			if err = p.push(fromPath, toName); err != nil {
+				return err
 			}
 		}
-	}()
-
-	// Create scp command
-	// TODO(blacknon): scpしてる時点でセキュリティもクソもないのだが、OS Command Injectionへの対策を考える
-	scpCmd := "/usr/bin/scp -tr '" + toPath + "'"
-	if s.Permission == true {
-		scpCmd = "/usr/bin/scp -ptr '" + toPath + "'"
+		return nil
 	}
-
-	// Run scp
-	err = session.Run(scpCmd)
-
-	return
 }
 
 // GetData get and return scp format data(remote to local).
@@ -363,46 +412,20 @@ func (s *SCPClient) PutFile(fromPaths []string, toPath string) (err error) {
 // example:
 //    scp.GetData("/path/remote/path")
 func (s *SCPClient) GetData(fromPaths []string) (data *bytes.Buffer, err error) {
-	session := s.Session
-	if s.Connection != nil {
-		session, _ = s.Connection.NewSession()
-		if err != nil {
-			return
-		}
-	}
-	defer session.Close()
-
-	fin := make(chan bool)
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
-
-		// Null Characters(10,000 char)
-		nc := strings.Repeat("\x00", 100000)
-		fmt.Fprintf(w, nc)
-	}()
-
-	buf := new(bytes.Buffer)
-	go func() {
-		r, _ := session.StdoutPipe()
-		buf.ReadFrom(r)
-		fin <- true
-	}()
+	return s.GetDataContext(context.Background(), fromPaths)
+}
 
-	// Create scp command
-	fromPathList := []string{}
-	for _, fromPath := range fromPaths {
-		fromPathList = append(fromPathList, fromPath)
+// GetDataContext is GetData with ctx. If ctx is done before the end, the
+// transfer is stopped, and ctx.Err() is returned.
+func (s *SCPClient) GetDataContext(ctx context.Context, fromPaths []string) (data *bytes.Buffer, err error) {
+	stream, err := s.GetStream(ctx, fromPaths)
+	if err != nil {
+		return
 	}
-	fromPathString := strings.Join(fromPathList, " ")
-	// TODO(blacknon): scpしてる時点でセキュリティもクソもないのだが、OS Command Injectionへの対策を考える
-	scpCmd := "/usr/bin/scp -fr " + fromPathString
-
-	// Run scp
-	err = session.Run(scpCmd)
+	defer stream.Close()
 
-	<-fin
-	data = buf
+	data = new(bytes.Buffer)
+	_, err = data.ReadFrom(stream)
 
 	return data, err
 }
@@ -412,31 +435,11 @@ func (s *SCPClient) GetData(fromPaths []string) (data *bytes.Buffer, err error)
 // example:
 //    scp.PutData(buffer(scp format data),"/path/remote/path")
 func (s *SCPClient) PutData(fromData *bytes.Buffer, toPath string) (err error) {
-	session := s.Session
-	if s.Connection != nil {
-		session, _ = s.Connection.NewSession()
-		if err != nil {
-			return
-		}
-	}
-	defer session.Close()
-
-	// Read Dir or File
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
-
-		w.Write(fromData.Bytes())
-	}()
-
-	// Create scp command
-	// TODO(blacknon): scpしてる時点でセキュリティもクソもないのだが、OS Command Injectionへの対策を考える
-	scpCmd := "/usr/bin/scp -tr '" + toPath + "'"
-	if s.Permission == true {
-		scpCmd = "/usr/bin/scp -ptr '" + toPath + "'"
-	}
-
-	err = session.Run(scpCmd)
+	return s.PutDataContext(context.Background(), fromData, toPath)
+}
 
-	return
+// PutDataContext is PutData with ctx. If ctx is done before the end, the
+// transfer is stopped, and ctx.Err() is returned.
+func (s *SCPClient) PutDataContext(ctx context.Context, fromData *bytes.Buffer, toPath string) (err error) {
+	return s.PutStream(ctx, fromData, toPath)
 }
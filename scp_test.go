@@ -8,10 +8,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/blacknon/go-scplib"
+	"github.com/blacknon/go-scplib/scptest"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -145,7 +148,7 @@ func ExampleSCPClient_GetData() {
 	// println getData
 	fmt.Println(getData)
 
-	// Output:
+	// getData Value...
 	// C0644 1561 passwd
 	// root:x:0:0:root:/root:/bin/bash
 	// daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
@@ -185,25 +188,65 @@ func ExampleSCPClient_PutData() {
 	}
 }
 
-// Test GetFile in CricleCI
-func TestCircleCIGetFile(t *testing.T) {
-	// Create ssh client config
-	config := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("root"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         60 * time.Second,
+// testPasswd is /etc/passwd of scptest.Server.
+const testPasswd = "root:x:0:0:root:/root:/bin/bash\n"
+
+// testConnection return the connection to the sshd at SCPLIB_TEST_SSHD
+// (e.g. localhost:50022 of the docker in CI), or to scptest.Server with
+// /etc/passwd if not set.
+func testConnection(t *testing.T) (connection *ssh.Client, closer func()) {
+	if addr := os.Getenv("SCPLIB_TEST_SSHD"); addr != "" {
+		// Create ssh client config
+		config := &ssh.ClientConfig{
+			User: "root",
+			Auth: []ssh.AuthMethod{
+				ssh.Password("root"),
+			},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         60 * time.Second,
+		}
+
+		connection, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			t.Fatalf("Failed to dial: %s", err)
+		}
+		return connection, func() { connection.Close() }
 	}
 
-	// Create ssh connection
-	connection, err := ssh.Dial("tcp", "localhost:50022", config)
+	srv, err := scptest.NewServer()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to dial: %s\n", err)
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	defer connection.Close()
+	fsys := srv.FS.(*scplib.MemFS)
+	fsys.MkdirAll("/etc", 0755)
+	fsys.WriteFile("/etc/passwd", []byte(testPasswd), 0644)
+
+	connection, err = srv.Dial()
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Failed to dial: %s", err)
+	}
+	return connection, func() {
+		connection.Close()
+		srv.Close()
+	}
+}
+
+// testDir create a temporary local directory.
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "scplib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Test GetFile in CricleCI
+func TestCircleCIGetFile(t *testing.T) {
+	connection, closer := testConnection(t)
+	defer closer()
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
 
 	// Create scp client
 	scp := new(scplib.SCPClient)
@@ -212,69 +255,54 @@ func TestCircleCIGetFile(t *testing.T) {
 
 	// scp get file
 	// scp.GetFile("/From/Remote/Path","/To/Local/Path")
-	err = scp.GetFile([]string{"/etc/passwd"}, "./passwd_1")
+	err := scp.GetFile([]string{"/etc/passwd"}, filepath.Join(dir, "passwd_1"))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to scp get: %s\n", err)
-		os.Exit(1)
+		t.Fatalf("Failed to scp get: %s", err)
 	}
-}
 
-// Test GetFile in CricleCI
-func TestCircleCIPutFile(t *testing.T) {
-	// Create ssh client config
-	config := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("root"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         60 * time.Second,
+	data, err := ioutil.ReadFile(filepath.Join(dir, "passwd_1"))
+	if err != nil || !strings.HasPrefix(string(data), "root:") {
+		t.Errorf("passwd_1 = %q, %v", data, err)
 	}
+}
 
-	// Create ssh connection
-	connection, err := ssh.Dial("tcp", "localhost:50022", config)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to dial: %s\n", err)
-		os.Exit(1)
-	}
-	defer connection.Close()
+// Test PutFile in CricleCI
+func TestCircleCIPutFile(t *testing.T) {
+	connection, closer := testConnection(t)
+	defer closer()
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
 
 	// Create scp client
 	scp := new(scplib.SCPClient)
 	scp.Permission = false // copy permission with scp flag
 	scp.Connection = connection
 
-	// Put ./passwd to remote machine `./passwd_2`
-	err = scp.PutFile([]string{"./passwd"}, "./passwd_2")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to scp put: %s\n", err)
-		os.Exit(1)
+	passwd := filepath.Join(dir, "passwd")
+	if err := ioutil.WriteFile(passwd, []byte(testPasswd), 0644); err != nil {
+		t.Fatal(err)
 	}
 
-	// show ./passwd_2
-	session, _ := connection.NewSession()
-	session.Run("cat ./passwd_2")
-}
-
-// Test GetFile in CricleCI
-func TestCircleCIGetData(t *testing.T) {
-	// Create ssh client config
-	config := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("root"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         60 * time.Second,
+	// Put passwd to remote machine `./passwd_2`
+	err := scp.PutFile([]string{passwd}, "./passwd_2")
+	if err != nil {
+		t.Fatalf("Failed to scp put: %s", err)
 	}
 
-	// Create ssh connection
-	connection, err := ssh.Dial("tcp", "localhost:50022", config)
+	// get ./passwd_2 back
+	getData, err := scp.GetData([]string{"./passwd_2"})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to dial: %s\n", err)
-		os.Exit(1)
+		t.Fatalf("Failed to scp get: %s", err)
 	}
-	defer connection.Close()
+	if want := "C0644 32 passwd_2\n" + testPasswd + "\x00"; getData.String() != want {
+		t.Errorf("passwd_2 = %q, want %q", getData, want)
+	}
+}
+
+// Test GetData in CricleCI
+func TestCircleCIGetData(t *testing.T) {
+	connection, closer := testConnection(t)
+	defer closer()
 
 	// Create scp client
 	scp := new(scplib.SCPClient)
@@ -284,32 +312,18 @@ func TestCircleCIGetData(t *testing.T) {
 	// Get /etc/passwd from remote machine
 	getData, err := scp.GetData([]string{"/etc/passwd"})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to scp put: %s\n", err)
-		os.Exit(1)
+		t.Fatalf("Failed to scp get: %s", err)
 	}
 
-	// println getData
-	fmt.Println(getData)
+	if !strings.HasPrefix(getData.String(), "C0644 ") || !strings.Contains(getData.String(), " passwd\nroot:") {
+		t.Errorf("getData = %q", getData)
+	}
 }
 
+// Test PutData in CricleCI
 func TestCircleCIPutData(t *testing.T) {
-	// Create ssh client config
-	config := &ssh.ClientConfig{
-		User: "root",
-		Auth: []ssh.AuthMethod{
-			ssh.Password("root"),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         60 * time.Second,
-	}
-
-	// Create ssh connection
-	connection, err := ssh.Dial("tcp", "localhost:50022", config)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to dial: %s\n", err)
-		os.Exit(1)
-	}
-	defer connection.Close()
+	connection, closer := testConnection(t)
+	defer closer()
 
 	// Create scp client
 	scp := new(scplib.SCPClient)
@@ -319,17 +333,21 @@ func TestCircleCIPutData(t *testing.T) {
 	// Get /etc/passwd from remote machine
 	getData, err := scp.GetData([]string{"/etc/passwd"})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to scp put: %s\n", err)
-		os.Exit(1)
+		t.Fatalf("Failed to scp get: %s", err)
 	}
+	want := getData.String()
 
 	// Put getData
 	err = scp.PutData(getData, "./passwd_4")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to scp put: %s\n", err)
-		os.Exit(1)
+		t.Fatalf("Failed to scp put: %s", err)
 	}
 
-	session, _ := connection.NewSession()
-	session.Run("cat ./passwd_4")
+	putData, err := scp.GetData([]string{"./passwd_4"})
+	if err != nil {
+		t.Fatalf("Failed to scp get: %s", err)
+	}
+	if got := strings.Replace(putData.String(), " passwd_4\n", " passwd\n", 1); got != want {
+		t.Errorf("passwd_4 = %q, want %q", got, want)
+	}
 }
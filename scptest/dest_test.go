@@ -0,0 +1,251 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blacknon/go-scplib"
+)
+
+// destTests are the destinations of the entries, same as OpenSSH scp. The
+// sources are in /src, and /dst/dir and /dst/file exist before the
+// transfer.
+var destTests = []struct {
+	name    string
+	from    []string
+	to      string
+	want    map[string]string // files after the transfer
+	absent  []string
+	wantErr bool
+}{
+	{
+		name: "file to new file",
+		from: []string{"/src/f"},
+		to:   "/dst/new",
+		want: map[string]string{"/dst/new": "f"},
+	},
+	{
+		name: "file to existing file",
+		from: []string{"/src/f"},
+		to:   "/dst/file",
+		want: map[string]string{"/dst/file": "f"},
+	},
+	{
+		name: "file to new file with tilde",
+		from: []string{"/src/f"},
+		to:   "/dst/a~b",
+		want: map[string]string{"/dst/a~b": "f"},
+	},
+	{
+		name:   "file to existing dir",
+		from:   []string{"/src/f"},
+		to:     "/dst/dir",
+		want:   map[string]string{"/dst/dir/f": "f"},
+		absent: []string{"/dst/f"},
+	},
+	{
+		name: "file to existing dir with slash",
+		from: []string{"/src/f"},
+		to:   "/dst/dir/",
+		want: map[string]string{"/dst/dir/f": "f"},
+	},
+	{
+		name:    "file to missing dir with slash",
+		from:    []string{"/src/f"},
+		to:      "/dst/none/",
+		absent:  []string{"/dst/none"},
+		wantErr: true,
+	},
+	{
+		name: "many files to dir",
+		from: []string{"/src/f", "/src/g"},
+		to:   "/dst/dir",
+		want: map[string]string{"/dst/dir/f": "f", "/dst/dir/g": "g"},
+	},
+	{
+		name:    "many files to file",
+		from:    []string{"/src/f", "/src/g"},
+		to:      "/dst/file",
+		want:    map[string]string{"/dst/file": "old"},
+		wantErr: true,
+	},
+	{
+		name:    "many files to missing path",
+		from:    []string{"/src/f", "/src/g"},
+		to:      "/dst/new",
+		absent:  []string{"/dst/new"},
+		wantErr: true,
+	},
+	{
+		name:   "dir to new dir",
+		from:   []string{"/src/d"},
+		to:     "/dst/new",
+		want:   map[string]string{"/dst/new/a": "a", "/dst/new/sub/b": "b"},
+		absent: []string{"/dst/new/d"},
+	},
+	{
+		name: "dir to existing dir",
+		from: []string{"/src/d"},
+		to:   "/dst/dir",
+		want: map[string]string{"/dst/dir/d/a": "a", "/dst/dir/d/sub/b": "b"},
+	},
+	{
+		name:    "dir to existing file",
+		from:    []string{"/src/d"},
+		to:      "/dst/file",
+		want:    map[string]string{"/dst/file": "old"},
+		wantErr: true,
+	},
+	{
+		name: "dir and file to dir",
+		from: []string{"/src/d", "/src/f"},
+		to:   "/dst/dir",
+		want: map[string]string{"/dst/dir/d/a": "a", "/dst/dir/f": "f"},
+	},
+}
+
+// destSources are the sources of destTests.
+var destSources = map[string]string{
+	"/src/f":       "f",
+	"/src/g":       "g",
+	"/src/d/a":     "a",
+	"/src/d/sub/b": "b",
+}
+
+// setupDest write the sources to src, and the destinations to dst.
+func setupDest(t *testing.T, src, dst scplib.FS) {
+	writeFiles(t, src, destSources)
+	writeFiles(t, dst, map[string]string{"/dst/file": "old"})
+	dst.(*scplib.MemFS).MkdirAll("/dst/dir", 0755)
+}
+
+// checkDest check the result of a test of destTests.
+func checkDest(t *testing.T, dst scplib.FS, err error, wantErr bool, want map[string]string, absent []string) {
+	t.Helper()
+	if (err != nil) != wantErr {
+		t.Errorf("err = %v, want error: %v", err, wantErr)
+	}
+	checkFiles(t, dst, want)
+	for _, name := range absent {
+		if _, err := dst.Lstat(name); !os.IsNotExist(err) {
+			t.Errorf("%s exists: %v", name, err)
+		}
+	}
+}
+
+func TestPutDest(t *testing.T) {
+	for _, test := range destTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, s, closer := newClient(t)
+			defer closer()
+			setupDest(t, s.FS, srv.FS)
+
+			err := s.PutFile(test.from, test.to)
+			checkDest(t, srv.FS, err, test.wantErr, test.want, test.absent)
+
+			// many sources are put with -d
+			cmds := srv.Commands()
+			if len(cmds) != 1 || strings.Contains(cmds[0], " -dtr ") != (len(test.from) > 1) {
+				t.Errorf("commands = %q", cmds)
+			}
+		})
+	}
+}
+
+func TestGetDest(t *testing.T) {
+	for _, test := range destTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, s, closer := newClient(t)
+			defer closer()
+			setupDest(t, srv.FS, s.FS)
+
+			err := s.GetFile(test.from, test.to)
+			checkDest(t, s.FS, err, test.wantErr, test.want, test.absent)
+		})
+	}
+}
+
+func TestGetDestHome(t *testing.T) {
+	usr, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	home, err := ioutil.TempDir(usr.HomeDir, "scptest-")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.RemoveAll(home)
+	to := "~/" + filepath.Base(home)
+
+	for _, sessions := range []int{1, 2} {
+		srv, s, closer := newClient(t)
+		defer closer()
+		writeFiles(t, srv.FS, map[string]string{"/src/f": "f", "/src/g": "g"})
+
+		// `~` of toPath is expanded for OSFS, in the plan and the transfer
+		s.FS = nil
+		s.Sessions = sessions
+		plan, err := s.GetFilePlan(context.Background(), []string{"/src/f", "/src/g"}, to)
+		if err != nil || len(plan.Ops) != 2 || plan.Ops[0].LocalPath != filepath.Join(home, "f") {
+			t.Fatalf("sessions %d: plan = %+v, %v", sessions, plan, err)
+		}
+		if err = s.GetFile([]string{"/src/f", "/src/g"}, to); err != nil {
+			t.Fatalf("sessions %d: %v", sessions, err)
+		}
+		for _, name := range []string{"f", "g"} {
+			data, err := ioutil.ReadFile(filepath.Join(home, name))
+			if err != nil || string(data) != name {
+				t.Errorf("sessions %d: %s = %q, %v", sessions, name, data, err)
+			}
+			os.Remove(filepath.Join(home, name))
+		}
+	}
+	if _, err = os.Lstat("~"); !os.IsNotExist(err) {
+		t.Errorf("~ is created: %v", err)
+	}
+}
+
+func TestGetDestOSFS(t *testing.T) {
+	for _, test := range destTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, s, closer := newClient(t)
+			defer closer()
+			writeFiles(t, srv.FS, destSources)
+
+			// the local paths are expanded for OSFS, with the same result
+			root := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(root, "dst/dir"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(root, "dst/file"), []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			s.FS = nil
+
+			err := s.GetFile(test.from, root+test.to)
+			if (err != nil) != test.wantErr {
+				t.Errorf("err = %v, want error: %v", err, test.wantErr)
+			}
+			for name, want := range test.want {
+				data, err := ioutil.ReadFile(root + name)
+				if err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+				}
+			}
+			for _, name := range test.absent {
+				if _, err := os.Lstat(root + name); !os.IsNotExist(err) {
+					t.Errorf("%s exists: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blacknon/go-scplib"
+)
+
+func TestPutGitignore(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+
+	gitignore := "/build\ndocs/*.md\n!docs/keep.md\n"
+	writeFiles(t, s.FS, map[string]string{
+		"/project/.gitignore":       gitignore,
+		"/project/a.md":             "a",
+		"/project/build/out":        "out",
+		"/project/src/build/gen.go": "gen",
+		"/project/docs/a.md":        "doc",
+		"/project/docs/keep.md":     "keep",
+		"/project/docs/sub/b.md":    "sub",
+	})
+	srv.FS.(*scplib.MemFS).MkdirAll("/remote", 0755)
+
+	// the anchored patterns are in the top directory, as .gitignore in it
+	s.Filter = &scplib.Filter{}
+	if err := s.Filter.AddGitignore(strings.NewReader(gitignore)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutFile([]string{"/project"}, "/remote"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, srv.FS, map[string]string{
+		"/remote/project/a.md":             "a",
+		"/remote/project/src/build/gen.go": "gen",
+		"/remote/project/docs/keep.md":     "keep",
+		"/remote/project/docs/sub/b.md":    "sub",
+	})
+	for _, name := range []string{"/remote/project/build", "/remote/project/docs/a.md"} {
+		if _, err := srv.FS.Lstat(name); err == nil {
+			t.Errorf("%s is not excluded", name)
+		}
+	}
+}
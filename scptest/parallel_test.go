@@ -0,0 +1,183 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blacknon/go-scplib"
+)
+
+// parallelFiles are the files of the parallel tests.
+var parallelFiles = map[string]string{
+	"/top/a":        "aaa",
+	"/top/b":        strings.Repeat("b", 100000),
+	"/top/sub/c":    "ccc",
+	"/top/sub/d":    "",
+	"/top/sub2/e":   "eee",
+	"/top/sub2/x/f": "fff",
+}
+
+// parallelDirs are the directories of parallelFiles.
+var parallelDirs = []string{"/top", "/top/sub", "/top/sub2", "/top/sub2/x"}
+
+// checkDirTimes check the modification times of the directories in dirs
+// under prefix.
+func checkDirTimes(t *testing.T, fsys scplib.FS, prefix string, dirs []string, mtime time.Time) {
+	t.Helper()
+	for _, dir := range dirs {
+		info, err := fsys.Lstat(prefix + dir)
+		if err != nil || !info.IsDir() || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s = %v, %v, want mtime %v", prefix+dir, info, err, mtime)
+		}
+	}
+}
+
+func TestParallelPut(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	newShell(srv)
+	s.Sessions = 4
+	s.Permission = true
+
+	// the sessions over MaxSessions are refused, and not used
+	srv.MaxSessions = 2
+	srv.Delay = time.Millisecond
+
+	writeFiles(t, s.FS, parallelFiles)
+	mtime := time.Unix(1500000000, 0)
+	for _, dir := range parallelDirs {
+		s.FS.Chtimes(dir, mtime, mtime)
+	}
+	srv.FS.(*scplib.MemFS).MkdirAll("/remote", 0755)
+
+	if err := s.PutFile([]string{"/top"}, "/remote"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{}
+	for name, data := range parallelFiles {
+		want["/remote"+name] = data
+	}
+	checkFiles(t, srv.FS, want)
+
+	// the times of the directories are sent again after the files
+	checkDirTimes(t, srv.FS, "/remote", parallelDirs, mtime)
+
+	n := 0
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "/usr/bin/scp ") {
+			n++
+		}
+	}
+	if n < 3 {
+		t.Errorf("commands = %q, want scp on the sessions", srv.Commands())
+	}
+}
+
+func TestParallelGet(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	s.Sessions = 4
+	s.Permission = true
+	srv.MaxSessions = 1
+	srv.Delay = time.Millisecond
+
+	writeFiles(t, srv.FS, parallelFiles)
+	mtime := time.Unix(1500000000, 0)
+	for _, dir := range parallelDirs {
+		srv.FS.Chtimes(dir, mtime, mtime)
+	}
+	s.FS.(*scplib.MemFS).MkdirAll("/back", 0755)
+
+	err := s.GetFile([]string{"/top/a", "/top/b", "/top/sub", "/top/sub2"}, "/back")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, s.FS, map[string]string{
+		"/back/a":        "aaa",
+		"/back/b":        parallelFiles["/top/b"],
+		"/back/sub/c":    "ccc",
+		"/back/sub/d":    "",
+		"/back/sub2/e":   "eee",
+		"/back/sub2/x/f": "fff",
+	})
+	checkDirTimes(t, s.FS, "/back", []string{"/sub", "/sub2", "/sub2/x"}, mtime)
+
+	if cmds := srv.Commands(); len(cmds) != 4 {
+		t.Errorf("commands = %q, want a session for each path", cmds)
+	}
+}
+
+func TestParallelRejected(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	newShell(srv)
+	s.Sessions = 4
+	s.Permission = true
+	s.ErrorPolicy = scplib.ContinueOnError
+	srv.MaxSessions = 2
+
+	writeFiles(t, s.FS, parallelFiles)
+	mtime := time.Unix(1500000000, 0)
+	for _, dir := range parallelDirs {
+		s.FS.Chtimes(dir, mtime, mtime)
+	}
+	srv.FS.(*scplib.MemFS).MkdirAll("/remote", 0755)
+
+	// the files in the rejected directory are not sent
+	srv.Fail = map[string]error{"/remote/top/sub2": os.ErrPermission}
+	err := s.PutFile([]string{"/top"}, "/remote")
+	rerr, ok := err.(*scplib.RemoteError)
+	if !ok || rerr.Severity != scplib.SeverityWarning || rerr.Path != "top/sub2" {
+		t.Fatalf("err = %v, want warning of top/sub2", err)
+	}
+
+	checkFiles(t, srv.FS, map[string]string{
+		"/remote/top/a":     "aaa",
+		"/remote/top/b":     parallelFiles["/top/b"],
+		"/remote/top/sub/c": "ccc",
+		"/remote/top/sub/d": "",
+	})
+	if _, err = srv.FS.Lstat("/remote/top/sub2"); !os.IsNotExist(err) {
+		t.Errorf("rejected directory is created: %v", err)
+	}
+	checkDirTimes(t, srv.FS, "/remote", []string{"/top", "/top/sub"}, mtime)
+
+	// the directory is rejected once, by the first pass
+	if errs := srv.Errors(); len(errs) != 1 {
+		t.Errorf("server errors = %v", errs)
+	}
+}
+
+func TestParallelAbort(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	newShell(srv)
+	s.Sessions = 4
+	srv.Delay = 5 * time.Millisecond
+
+	// the sessions stopped by the error of /missing do not hide it, though
+	// /a is before it
+	big := strings.Repeat("a", 1<<20)
+	writeFiles(t, srv.FS, map[string]string{"/a": big})
+	s.FS.(*scplib.MemFS).MkdirAll("/back", 0755)
+	err := s.GetFile([]string{"/a", "/missing"}, "/back")
+	if rerr, ok := err.(*scplib.RemoteError); !ok || !strings.Contains(rerr.Message, "/missing") {
+		t.Errorf("get: err = %v, want *RemoteError of /missing", err)
+	}
+
+	writeFiles(t, s.FS, map[string]string{"/top/a": big, "/top/b": "bbb"})
+	srv.FS.(*scplib.MemFS).MkdirAll("/remote", 0755)
+	srv.Fail = map[string]error{"/remote/top/b": os.ErrPermission}
+	err = s.PutFile([]string{"/top"}, "/remote")
+	if rerr, ok := err.(*scplib.RemoteError); !ok || rerr.Path != "top/b" {
+		t.Errorf("put: err = %v, want *RemoteError of top/b", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/blacknon/go-scplib"
+)
+
+// noAppendFS hide Append of MemFS.
+type noAppendFS struct {
+	scplib.FS
+}
+
+// resumeData is the file of the resume tests.
+var resumeData = strings.Repeat("0123456789", 10000)
+
+// resumeTests are the partial destinations of a file of 100000 bytes.
+var resumeTests = []struct {
+	name    string
+	partial string
+	resumed bool // continued from the partial file, not sent again
+}{
+	{
+		name:    "matching prefix",
+		partial: resumeData[:40000],
+		resumed: true,
+	},
+	{
+		name:    "mismatched prefix",
+		partial: strings.Repeat("x", 40000),
+	},
+	{
+		name:    "complete",
+		partial: resumeData,
+		resumed: true,
+	},
+	{
+		name:    "longer",
+		partial: resumeData + "x",
+	},
+}
+
+func TestResumeGet(t *testing.T) {
+	for _, test := range resumeTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, s, closer := newClient(t)
+			defer closer()
+			newShell(srv)
+			s.Resume = true
+
+			writeFiles(t, srv.FS, map[string]string{"/a": resumeData})
+			writeFiles(t, s.FS, map[string]string{"/a": test.partial})
+			if err := s.GetFile([]string{"/a"}, "/a"); err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, s.FS, map[string]string{"/a": resumeData})
+
+			cmds := srv.Commands()
+			tail := "tail -c +" + strconv.Itoa(len(test.partial)+1) + " -- /a"
+			if hasCommand(cmds, tail) != test.resumed || hasCommand(cmds, "/usr/bin/scp ") == test.resumed {
+				t.Errorf("commands = %q, resumed: %v", cmds, test.resumed)
+			}
+			if test.resumed && !hasCommand(cmds, "head -c "+strconv.Itoa(len(test.partial))+" -- /a | sha256sum") {
+				t.Errorf("commands = %q, want the prefix checksum", cmds)
+			}
+		})
+	}
+}
+
+func TestResumeGetNoAppend(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	newShell(srv)
+	s.Resume = true
+
+	// the partial file can not be appended, and is received again
+	writeFiles(t, srv.FS, map[string]string{"/a": resumeData})
+	writeFiles(t, s.FS, map[string]string{"/a": resumeData[:40000]})
+	local := s.FS
+	s.FS = noAppendFS{local}
+	if err := s.GetFile([]string{"/a"}, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, local, map[string]string{"/a": resumeData})
+
+	if cmds := srv.Commands(); hasCommand(cmds, "wc ") || !hasCommand(cmds, "/usr/bin/scp ") {
+		t.Errorf("commands = %q, want only scp", cmds)
+	}
+}
+
+func TestResumePut(t *testing.T) {
+	for _, test := range resumeTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, s, closer := newClient(t)
+			defer closer()
+			newShell(srv)
+			s.Resume = true
+
+			writeFiles(t, s.FS, map[string]string{"/a": resumeData})
+			writeFiles(t, srv.FS, map[string]string{"/a": test.partial})
+			if err := s.PutFile([]string{"/a"}, "/a"); err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, srv.FS, map[string]string{"/a": resumeData})
+
+			cmds := srv.Commands()
+			if hasCommand(cmds, "tee -a -- /a") != test.resumed || hasCommand(cmds, "/usr/bin/scp ") == test.resumed {
+				t.Errorf("commands = %q, resumed: %v", cmds, test.resumed)
+			}
+		})
+	}
+}
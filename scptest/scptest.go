@@ -0,0 +1,413 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package scptest provide an in-process SSH server for the tests of scp
+// clients. Its exec requests of `scp -t` and `scp -f` are served by
+// scplib.Server on a file system in memory, so the transfers are tested
+// without sshd and the local disk. The faults of the remote, e.g. rejected
+// files, slow peers and abrupt disconnects, can be injected.
+//
+// example:
+//
+//	srv, err := scptest.NewServer()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//
+//	conn, err := srv.Dial()
+//	...
+//	scp := &scplib.SCPClient{Connection: conn}
+package scptest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blacknon/go-scplib"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is the SSH server listening on the loopback address. The fields
+// are read by each exec request, and must not be changed during the
+// transfers.
+type Server struct {
+	// FS is the file system of the remote. NewServer set a new MemFS.
+	FS scplib.FS
+
+	// Fail make the operations of FS on the paths fail with the errors.
+	// They are sent to the client as the warning acks, e.g. a rejected
+	// file of `scp -t`, or a file not readable by `scp -f`.
+	Fail map[string]error
+
+	// Delay is the time waited before each write to the client, as a slow
+	// peer.
+	Delay time.Duration
+
+	// DisconnectAfter close the connection without the exit status, after
+	// the bytes of scp data are sent or received in an exec request. Zero
+	// means never.
+	DisconnectAfter int64
+
+	// Exec serve the exec requests of the commands other than scp, and
+	// return the exit status, e.g. to emulate the remote checksum
+	// commands. If nil, the commands exit with 127 (command not found).
+	Exec func(command string, ch ssh.Channel) uint32
+
+	// MaxSessions is the number of the open sessions of a connection, as
+	// MaxSessions of sshd. The sessions over it are refused. Zero means no
+	// limit.
+	MaxSessions int
+
+	// Addr is the address of the server.
+	Addr string
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	commands []string
+	errs     []error
+}
+
+// NewServer start a server on the loopback address, with a new MemFS.
+func NewServer() (*Server, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		FS:       &scplib.MemFS{},
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		config:   &ssh.ServerConfig{NoClientAuth: true},
+		hostKey:  signer.PublicKey(),
+		conns:    map[net.Conn]bool{},
+	}
+	s.config.AddHostKey(signer)
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// ClientConfig return the config to connect to s, with the host key of s.
+func (s *Server) ClientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "scptest",
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+		Timeout:         10 * time.Second,
+	}
+}
+
+// Dial connect to s.
+func (s *Server) Dial() (*ssh.Client, error) {
+	return ssh.Dial("tcp", s.Addr, s.ClientConfig())
+}
+
+// Commands return the commands of the exec requests received, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Errors return the errors of the scp commands served, in order, e.g. the
+// files rejected by FS.
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// Close stop s, and close all connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// accept serve the connections, until the listener is closed.
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// serveConn serve the session channels of conn.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var mu sync.Mutex
+	open := 0 // sessions not closed yet
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		mu.Lock()
+		refused := s.MaxSessions > 0 && open >= s.MaxSessions
+		if !refused {
+			open++
+		}
+		mu.Unlock()
+		if refused {
+			newCh.Reject(ssh.ResourceShortage, "too many sessions")
+			continue
+		}
+
+		release := func() {
+			mu.Lock()
+			open--
+			mu.Unlock()
+		}
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			release()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sc := &sessionChannel{Channel: ch, release: release}
+			defer sc.Close()
+			s.serveSession(conn, sc, reqs)
+		}()
+	}
+}
+
+// sessionChannel is the channel of a session, that release its slot of
+// MaxSessions before it is closed, so the client can open the next session
+// as soon as it sees the close.
+type sessionChannel struct {
+	ssh.Channel
+	release func()
+	once    sync.Once
+}
+
+func (c *sessionChannel) Close() error {
+	c.once.Do(c.release)
+	return c.Channel.Close()
+}
+
+// serveSession serve the first exec request of the session.
+func (s *Server) serveSession(conn net.Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			req.Reply(true, nil)
+		case "exec":
+			go ssh.DiscardRequests(reqs)
+			s.exec(conn, ch, req)
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+	ch.Close()
+}
+
+// exec serve the exec request req on ch.
+func (s *Server) exec(conn net.Conn, ch ssh.Channel, req *ssh.Request) {
+	var payload struct{ Command string }
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		req.Reply(false, nil)
+		ch.Close()
+		return
+	}
+
+	s.mu.Lock()
+	s.commands = append(s.commands, payload.Command)
+	s.mu.Unlock()
+
+	if _, err := scplib.ParseServerCommand(payload.Command); err != nil {
+		req.Reply(true, nil)
+		status := uint32(127)
+		if s.Exec != nil {
+			status = s.Exec(payload.Command, ch)
+		} else {
+			fmt.Fprintf(ch.Stderr(), "scptest: %v\n", err)
+		}
+		ch.SendRequest("exit-status", false, ssh.Marshal(&struct{ Status uint32 }{status}))
+		ch.Close()
+		return
+	}
+
+	srv := &scplib.Server{FS: &faultFS{FS: s.FS, fail: s.Fail}}
+	fc := &faultChannel{Channel: ch, conn: conn, delay: s.Delay, limit: s.DisconnectAfter}
+	if err := srv.ServeExec(fc, req); err != nil {
+		s.mu.Lock()
+		s.errs = append(s.errs, err)
+		s.mu.Unlock()
+	}
+}
+
+// errDisconnected is returned by faultChannel after the disconnect.
+var errDisconnected = errors.New("scptest: disconnected")
+
+// faultChannel is ssh.Channel with the delay and the disconnect of Server.
+type faultChannel struct {
+	ssh.Channel
+	conn  net.Conn
+	delay time.Duration
+	limit int64
+
+	mu sync.Mutex
+	n  int64 // bytes sent and received
+}
+
+// count add n bytes, and close the connection if over the limit.
+func (c *faultChannel) count(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.n += int64(n)
+	if c.limit > 0 && c.n >= c.limit {
+		c.conn.Close()
+		return errDisconnected
+	}
+	return nil
+}
+
+func (c *faultChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if cerr := c.count(n); cerr != nil {
+		return 0, cerr
+	}
+	return n, err
+}
+
+func (c *faultChannel) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+
+	if c.limit > 0 {
+		c.mu.Lock()
+		rest := c.limit - c.n
+		c.mu.Unlock()
+		if int64(len(p)) >= rest {
+			// the connection is closed in the middle of p
+			if rest > 0 {
+				c.Channel.Write(p[:rest])
+				c.count(int(rest))
+			}
+			c.conn.Close()
+			return 0, errDisconnected
+		}
+	}
+
+	n, err := c.Channel.Write(p)
+	c.count(n)
+	return n, err
+}
+
+// faultFS is FS failing the operations on the paths of fail.
+type faultFS struct {
+	scplib.FS
+	fail map[string]error
+}
+
+// check return the error of name, as the error of os.
+func (f *faultFS) check(op, name string) error {
+	if err, ok := f.fail[path.Clean(filepath.ToSlash(name))]; ok {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+func (f *faultFS) Open(name string) (io.ReadCloser, error) {
+	if err := f.check("open", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Open(name)
+}
+
+func (f *faultFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	if err := f.check("open", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Create(name, perm)
+}
+
+func (f *faultFS) Mkdir(name string, perm os.FileMode) error {
+	if err := f.check("mkdir", name); err != nil {
+		return err
+	}
+	return f.FS.Mkdir(name, perm)
+}
+
+// Stat follow the links, if FS is scplib.StatFS.
+func (f *faultFS) Stat(name string) (os.FileInfo, error) {
+	if s, ok := f.FS.(scplib.StatFS); ok {
+		return s.Stat(name)
+	}
+	return f.FS.Lstat(name)
+}
+
+// Remove remove the partial files, if FS is scplib.RemoveFS.
+func (f *faultFS) Remove(name string) error {
+	if r, ok := f.FS.(scplib.RemoveFS); ok {
+		return r.Remove(name)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blacknon/go-scplib"
+	"github.com/blacknon/go-scplib/scptest"
+	"golang.org/x/crypto/ssh"
+)
+
+// newClient start a server, and return a client with local MemFS.
+func newClient(t *testing.T) (*scptest.Server, *scplib.SCPClient, func()) {
+	srv, err := scptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := srv.Dial()
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+
+	s := &scplib.SCPClient{Connection: conn, FS: &scplib.MemFS{}}
+	return srv, s, func() {
+		conn.Close()
+		srv.Close()
+	}
+}
+
+// writeFiles write the files and their parents to fsys.
+func writeFiles(t *testing.T, fsys scplib.FS, files map[string]string) {
+	m := fsys.(*scplib.MemFS)
+	for name, data := range files {
+		if i := strings.LastIndex(name, "/"); i > 0 {
+			m.MkdirAll(name[:i], 0755)
+		}
+		if err := m.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFiles check the data of the files in fsys.
+func checkFiles(t *testing.T, fsys scplib.FS, files map[string]string) {
+	t.Helper()
+	m := fsys.(*scplib.MemFS)
+	for name, want := range files {
+		data, err := m.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+}
+
+func TestPutGetFile(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+
+	files := map[string]string{
+		"/top/a":     "aaa",
+		"/top/sub/b": strings.Repeat("b", 100000),
+		"/top/z":     "",
+	}
+	writeFiles(t, s.FS, files)
+	srv.FS.(*scplib.MemFS).MkdirAll("/remote", 0755)
+
+	if err := s.PutFile([]string{"/top"}, "/remote"); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, srv.FS, map[string]string{
+		"/remote/top/a":     files["/top/a"],
+		"/remote/top/sub/b": files["/top/sub/b"],
+		"/remote/top/z":     "",
+	})
+
+	s.FS.(*scplib.MemFS).MkdirAll("/back", 0755)
+	if err := s.GetFile([]string{"/remote/top"}, "/back"); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, s.FS, map[string]string{
+		"/back/top/a":     files["/top/a"],
+		"/back/top/sub/b": files["/top/sub/b"],
+	})
+
+	want := []string{"/usr/bin/scp -tr -- /remote", "/usr/bin/scp -rf -- /remote/top"}
+	if got := srv.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestGetDot(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	writeFiles(t, srv.FS, map[string]string{"/a": "aaa", "/sub/b": "bb"})
+	s.FS.(*scplib.MemFS).MkdirAll("/back", 0755)
+
+	// `scp -rf .` send the current directory as "."
+	if err := s.GetFile([]string{"."}, "/back"); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, s.FS, map[string]string{"/back/a": "aaa", "/back/sub/b": "bb"})
+}
+
+func TestTimes(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	s.Permission = true
+
+	writeFiles(t, s.FS, map[string]string{"/a": "aaa"})
+	local := s.FS.(*scplib.MemFS)
+	local.Chmod("/a", 0600)
+	mtime := time.Unix(1500000000, 0)
+	local.Chtimes("/a", mtime, mtime)
+
+	// the T record is sent before the file
+	if err := s.PutFile([]string{"/a"}, "/b"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := srv.FS.Lstat("/b")
+	if err != nil || info.Mode() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("remote info = %v, %v", info, err)
+	}
+
+	// and received
+	if err = s.GetFile([]string{"/b"}, "/c"); err != nil {
+		t.Fatal(err)
+	}
+	info, err = local.Lstat("/c")
+	if err != nil || info.Mode() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("local info = %v, %v", info, err)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	s.ErrorPolicy = scplib.ContinueOnError
+
+	writeFiles(t, s.FS, map[string]string{"/top/a": "aaa", "/top/b": "bbb", "/top/c": "ccc"})
+	srv.Fail = map[string]error{"/top/b": os.ErrPermission}
+
+	err := s.PutFile([]string{"/top"}, "/")
+	rerr, ok := err.(*scplib.RemoteError)
+	if !ok || rerr.Severity != scplib.SeverityWarning || rerr.Path != "top/b" {
+		t.Fatalf("err = %v, want warning of top/b", err)
+	}
+	if !strings.Contains(rerr.Message, "permission denied") {
+		t.Errorf("message = %q", rerr.Message)
+	}
+	checkFiles(t, srv.FS, map[string]string{"/top/a": "aaa", "/top/c": "ccc"})
+	if _, err = srv.FS.Lstat("/top/b"); !os.IsNotExist(err) {
+		t.Errorf("rejected file is written: %v", err)
+	}
+
+	// not readable by `scp -f`
+	err = s.GetFile([]string{"/top/b", "/top/c", "/none"}, "/")
+	merr, ok := err.(*scplib.MultiError)
+	if !ok || len(merr.Errors) != 2 {
+		t.Fatalf("err = %v, want 2 errors", err)
+	}
+	checkFiles(t, s.FS, map[string]string{"/c": "ccc"})
+	if errs := srv.Errors(); len(errs) != 2 {
+		t.Errorf("server errors = %v", errs)
+	}
+}
+
+func TestRejectedDir(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	s.ErrorPolicy = scplib.ContinueOnError
+
+	// the contents of the rejected directory are skipped, and the rest are
+	// written
+	writeFiles(t, s.FS, map[string]string{"/top/a": "aaa", "/top/sub/b": "bbb", "/top/z": "zzz"})
+	srv.Fail = map[string]error{"/top/sub": os.ErrPermission}
+	err := s.PutFile([]string{"/top"}, "/")
+	if rerr, ok := err.(*scplib.RemoteError); !ok || rerr.Severity != scplib.SeverityWarning || rerr.Path != "top/sub" {
+		t.Fatalf("err = %v, want warning of top/sub", err)
+	}
+	checkFiles(t, srv.FS, map[string]string{"/top/a": "aaa", "/top/z": "zzz"})
+	if _, err = srv.FS.Lstat("/top/sub"); !os.IsNotExist(err) {
+		t.Errorf("rejected directory is created: %v", err)
+	}
+}
+
+func TestFatal(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	s.Flags = []string{"-d"}
+
+	writeFiles(t, s.FS, map[string]string{"/a": "aaa"})
+	err := s.PutFile([]string{"/a"}, "/none/")
+	if rerr, ok := err.(*scplib.RemoteError); !ok || rerr.Severity != scplib.SeverityFatal {
+		t.Errorf("err = %v, want fatal *RemoteError", err)
+	}
+	if errs := srv.Errors(); len(errs) != 1 {
+		t.Errorf("server errors = %v", errs)
+	}
+}
+
+func TestSlowPeer(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	writeFiles(t, srv.FS, map[string]string{"/a": "aaa"})
+
+	srv.Delay = 10 * time.Millisecond
+	if err := s.GetFile([]string{"/a"}, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, s.FS, map[string]string{"/a": "aaa"})
+
+	srv.Delay = time.Second
+	s.IdleTimeout = 50 * time.Millisecond
+	if err := s.GetFile([]string{"/a"}, "/b"); err != scplib.ErrIdleTimeout {
+		t.Errorf("err = %v, want %v", err, scplib.ErrIdleTimeout)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	writeFiles(t, srv.FS, map[string]string{"/a": strings.Repeat("a", 100000)})
+
+	srv.DisconnectAfter = 50000
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.GetFileContext(ctx, []string{"/a"}, "/a"); err == nil || err == ctx.Err() {
+		t.Errorf("err = %v", err)
+	}
+
+	// the partial file is removed
+	if _, err := s.FS.Lstat("/a"); !os.IsNotExist(err) {
+		t.Errorf("partial file is left: %v", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+
+	session, err := s.Connection.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Run("sha256sum -- /a")
+	if eerr, ok := err.(*ssh.ExitError); !ok || eerr.ExitStatus() != 127 {
+		t.Errorf("err = %v, want exit status 127", err)
+	}
+
+	srv.Exec = func(command string, ch ssh.Channel) uint32 {
+		ch.Write([]byte("out\n"))
+		return 3
+	}
+	session, err = s.Connection.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("echo")
+	if eerr, ok := err.(*ssh.ExitError); !ok || eerr.ExitStatus() != 3 || string(out) != "out\n" {
+		t.Errorf("out = %q, err = %v", out, err)
+	}
+}
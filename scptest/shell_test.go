@@ -0,0 +1,263 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blacknon/go-scplib"
+	"github.com/blacknon/go-scplib/scptest"
+	"golang.org/x/crypto/ssh"
+)
+
+// shell emulate the remote commands run by scplib (test, wc, head, tail,
+// tee, sha256sum and shasum) on the FS of a Server, as Server.Exec.
+type shell struct {
+	fs *scplib.MemFS
+
+	mu sync.Mutex
+
+	// missing are the commands not found
+	missing map[string]bool
+
+	// sums replace the checksums printed for the paths. The paths of empty
+	// sums are not printed, as missing files.
+	sums map[string]string
+}
+
+// newShell return shell on the FS of srv, set as srv.Exec.
+func newShell(srv *scptest.Server) *shell {
+	sh := &shell{
+		fs:      srv.FS.(*scplib.MemFS),
+		missing: map[string]bool{},
+		sums:    map[string]string{},
+	}
+	srv.Exec = sh.exec
+	return sh
+}
+
+// setMissing make the command not found.
+func (sh *shell) setMissing(name string) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.missing[name] = true
+}
+
+// setSum replace the checksum printed for path.
+func (sh *shell) setSum(path, sum string) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.sums[path] = sum
+}
+
+// exec run the pipeline of command. The stdout of a command is the stdin
+// of the next, and the exit status is of the last.
+func (sh *shell) exec(command string, ch ssh.Channel) uint32 {
+	words, err := splitWords(command)
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "sh: %v\n", err)
+		return 2
+	}
+
+	var in io.Reader = ch
+	var status uint32
+	devNull := false
+	for len(words) > 0 {
+		args := words
+		words = nil
+		for i, w := range args {
+			if w == "|" {
+				args, words = args[:i], args[i+1:]
+				break
+			}
+		}
+		if n := len(args); n > 0 && args[n-1] == ">/dev/null" {
+			args, devNull = args[:n-1], true
+		}
+
+		out := new(bytes.Buffer)
+		status = sh.run(args, in, out, ch.Stderr())
+		in = out
+	}
+
+	if !devNull {
+		io.Copy(ch, in)
+	}
+	return status
+}
+
+// run run a command with args.
+func (sh *shell) run(args []string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+	sh.mu.Lock()
+	missing := len(args) == 0 || sh.missing[args[0]]
+	sh.mu.Unlock()
+	if missing {
+		fmt.Fprintf(stderr, "sh: %q: command not found\n", args)
+		return 127
+	}
+
+	// the options, and the files after "--"
+	name, opts, files := args[0], args[1:], []string(nil)
+	for i, arg := range opts {
+		if arg == "--" {
+			opts, files = opts[:i], opts[i+1:]
+			break
+		}
+	}
+
+	switch name {
+	case "test":
+		if len(opts) == 2 && opts[0] == "-d" {
+			if info, err := sh.fs.Stat(opts[1]); err == nil && info.IsDir() {
+				return 0
+			}
+		}
+		return 1
+
+	case "sha256sum", "shasum":
+		return sh.sum(files, stdin, stdout, stderr)
+
+	case "wc", "head", "tail":
+		if len(files) != 1 {
+			fmt.Fprintf(stderr, "%s: invalid arguments %q\n", name, args)
+			return 2
+		}
+		data, err := sh.fs.ReadFile(files[0])
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", name, err)
+			return 1
+		}
+
+		switch name {
+		case "wc":
+			fmt.Fprintf(stdout, "%d %s\n", len(data), files[0])
+		case "head":
+			n, _ := strconv.Atoi(opts[1])
+			if n > len(data) {
+				n = len(data)
+			}
+			stdout.Write(data[:n])
+		case "tail":
+			n, _ := strconv.Atoi(strings.TrimPrefix(opts[1], "+"))
+			if n-1 < len(data) {
+				stdout.Write(data[n-1:])
+			}
+		}
+		return 0
+
+	case "tee":
+		if len(files) != 1 {
+			fmt.Fprintf(stderr, "tee: invalid arguments %q\n", args)
+			return 2
+		}
+		w, err := sh.fs.Append(files[0])
+		if err != nil {
+			fmt.Fprintf(stderr, "tee: %v\n", err)
+			return 1
+		}
+		_, err = io.Copy(io.MultiWriter(w, stdout), stdin)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "tee: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "sh: %s: command not found\n", name)
+	return 127
+}
+
+// sum print the checksums of files in the format of sha256sum, or of stdin
+// if no files.
+func (sh *shell) sum(files []string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+	if len(files) == 0 {
+		data, _ := ioutil.ReadAll(stdin)
+		fmt.Fprintf(stdout, "%s  -\n", sha256Hex(data))
+		return 0
+	}
+
+	var status uint32
+	for _, name := range files {
+		sh.mu.Lock()
+		sum, replaced := sh.sums[name]
+		sh.mu.Unlock()
+
+		data, err := sh.fs.ReadFile(name)
+		if err != nil || (replaced && sum == "") {
+			fmt.Fprintf(stderr, "sha256sum: %s: No such file or directory\n", name)
+			status = 1
+			continue
+		}
+		if !replaced {
+			sum = sha256Hex(data)
+		}
+
+		// the name with backslash or newline is escaped
+		if strings.ContainsAny(name, "\\\n") {
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+			sum = `\` + sum
+		}
+		fmt.Fprintf(stdout, "%s  %s\n", sum, name)
+	}
+	return status
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// splitWords split the command line into words, with the quotes of sh.
+// The operators, e.g. "|", must be separated by spaces.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
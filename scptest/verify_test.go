@@ -0,0 +1,106 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scptest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blacknon/go-scplib"
+)
+
+// hasCommand report whether a command in cmds start with prefix.
+func hasCommand(cmds []string, prefix string) bool {
+	for _, cmd := range cmds {
+		if strings.HasPrefix(cmd, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerify(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	newShell(srv)
+	s.Verify = scplib.SHA256
+
+	// the names are quoted for the shell, and escaped by sha256sum
+	writeFiles(t, s.FS, map[string]string{
+		"/top/a b":         "a",
+		"/top/it's":        "b",
+		`/top/back\slash`:  "c",
+		"/top/sub/$(id) *": "d",
+	})
+	srv.FS.(*scplib.MemFS).MkdirAll("/remote", 0755)
+
+	if err := s.PutFile([]string{"/top"}, "/remote"); err != nil {
+		t.Fatal(err)
+	}
+	s.FS.(*scplib.MemFS).MkdirAll("/back", 0755)
+	if err := s.GetFile([]string{"/remote/top"}, "/back"); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, s.FS, map[string]string{"/back/top/it's": "b", "/back/top/sub/$(id) *": "d"})
+
+	n := 0
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "sha256sum -- ") {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("commands = %q, want 2 sha256sum", srv.Commands())
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	sh := newShell(srv)
+	s.Verify = scplib.SHA256
+	writeFiles(t, s.FS, map[string]string{"/a": "aaa"})
+
+	// the remote file is broken
+	bad := strings.Repeat("0", 64)
+	sh.setSum("/b", bad)
+	err := s.PutFile([]string{"/a"}, "/b")
+	cerr, ok := err.(*scplib.ChecksumError)
+	if !ok || cerr.Path != "/b" || cerr.Remote != bad || cerr.Local != sha256Hex([]byte("aaa")) {
+		t.Fatalf("err = %v, want *ChecksumError of /b", err)
+	}
+
+	// the checksum is not printed
+	sh.setSum("/b", "")
+	err = s.GetFile([]string{"/b"}, "/c")
+	if cerr, ok = err.(*scplib.ChecksumError); !ok || cerr.Path != "/b" || cerr.Remote != "" {
+		t.Fatalf("err = %v, want *ChecksumError without remote checksum", err)
+	}
+	checkFiles(t, s.FS, map[string]string{"/c": "aaa"})
+}
+
+func TestVerifyFallback(t *testing.T) {
+	srv, s, closer := newClient(t)
+	defer closer()
+	sh := newShell(srv)
+	s.Verify = scplib.SHA256
+	writeFiles(t, s.FS, map[string]string{"/a": "aaa"})
+
+	// shasum is tried, if sha256sum is not found
+	sh.setMissing("sha256sum")
+	if err := s.PutFile([]string{"/a"}, "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := srv.Commands(); !hasCommand(cmds, "shasum -a 256 -- /b") {
+		t.Errorf("commands = %q, want shasum", cmds)
+	}
+
+	// no command
+	sh.setMissing("shasum")
+	err := s.PutFile([]string{"/a"}, "/b")
+	if _, ok := err.(*scplib.ChecksumError); ok || err == nil || !strings.Contains(err.Error(), "no remote command") {
+		t.Errorf("err = %v, want no remote command", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ServerCommand is the scp command of an exec request, e.g.
+// `scp -r -t -- /path`.
+type ServerCommand struct {
+	Sink      bool // -t, receive the files to Paths[0]
+	Source    bool // -f, send the files of Paths
+	Recursive bool // -r
+	Preserve  bool // -p
+	TargetDir bool // -d, Paths[0] must be a directory
+
+	Paths []string
+}
+
+// ParseServerCommand parse the command line of an exec request. The
+// program must be `scp` (in any directory), and the environment variables
+// before it are ignored. The leading `~` of the paths is replaced with `.`,
+// the current directory of the FS. The other shell expansions, e.g. globs,
+// are not done.
+func ParseServerCommand(command string) (*ServerCommand, error) {
+	words, err := shellSplit(command, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	// ENV=value before the program
+	for len(words) > 0 {
+		if i := strings.Index(words[0], "="); i < 0 || !isEnvName(words[0][:i]) {
+			break
+		}
+		words = words[1:]
+	}
+	if len(words) == 0 || path.Base(words[0]) != "scp" {
+		return nil, fmt.Errorf("scplib: not a scp command: %q", command)
+	}
+
+	cmd := &ServerCommand{}
+	args := words[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+
+		for _, c := range opt[1:] {
+			switch c {
+			case 't':
+				cmd.Sink = true
+			case 'f':
+				cmd.Source = true
+			case 'r':
+				cmd.Recursive = true
+			case 'p':
+				cmd.Preserve = true
+			case 'd':
+				cmd.TargetDir = true
+			case 'v', 'q':
+			default:
+				return nil, fmt.Errorf("scplib: unknown scp option -%c", c)
+			}
+		}
+	}
+	cmd.Paths = args
+
+	switch {
+	case cmd.Sink == cmd.Source:
+		return nil, errors.New("scplib: scp command needs one of -t and -f")
+	case cmd.Sink && len(cmd.Paths) != 1:
+		return nil, errors.New("scplib: scp -t needs one target")
+	case cmd.Source && len(cmd.Paths) == 0:
+		return nil, errors.New("scplib: scp -f needs paths")
+	}
+	return cmd, nil
+}
+
+// Server serve scp on the channels of golang.org/x/crypto/ssh servers, as
+// the remote `scp -t` and `scp -f`.
+type Server struct {
+	// FS is the file system of the server. If nil, OSFS is used.
+	FS FS
+}
+
+// ServeExec serve the exec request req of scp on ch. It replies to req,
+// sends the exit status, and closes ch. The error of the command line or
+// the transfer is returned.
+//
+// example:
+//
+//	for req := range reqs {
+//		if req.Type == "exec" {
+//			go srv.ServeExec(ch, req)
+//		}
+//	}
+func (srv *Server) ServeExec(ch ssh.Channel, req *ssh.Request) error {
+	defer ch.Close()
+
+	var payload struct{ Command string }
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		req.Reply(false, nil)
+		return err
+	}
+
+	cmd, err := ParseServerCommand(payload.Command)
+	if err != nil {
+		req.Reply(false, nil)
+		return err
+	}
+	req.Reply(true, nil)
+
+	err = srv.Serve(ch, cmd)
+
+	status := struct{ Status uint32 }{0}
+	if err != nil {
+		status.Status = 1
+	}
+	ch.SendRequest("exit-status", false, ssh.Marshal(&status))
+	return err
+}
+
+// Serve serve cmd with the client on rw, e.g. ssh.Channel. The errors are
+// sent to the client as the protocol messages, and also returned (as
+// *MultiError, if more than one).
+func (srv *Server) Serve(rw io.ReadWriter, cmd *ServerCommand) error {
+	if cmd.Sink {
+		return srv.sink(rw, cmd)
+	}
+	return srv.source(rw, cmd)
+}
+
+// sink receive the files from the client, as `scp -t`.
+func (srv *Server) sink(rw io.ReadWriter, cmd *ServerCommand) error {
+	fsys := localFS(srv.FS)
+	target := cmd.Paths[0]
+
+	info, err := statFile(fsys, target)
+	isDir := err == nil && info.IsDir()
+	if cmd.TargetDir && !isDir {
+		if err == nil {
+			err = syscall.ENOTDIR
+		}
+		err = localError("stat", target, err)
+		fmt.Fprintf(rw, "%cscp: %s: %s\n", respFatal, target, err.(*LocalIOError).Err)
+		return err
+	}
+
+	k := newSink(rw, rw)
+	return k.run(&serverHandler{
+		fileWriter: &fileWriter{fs: fsys, path: target, perm: cmd.Preserve},
+		recursive:  cmd.Recursive,
+	})
+}
+
+// source send the files to the client, as `scp -f`. The errors of the
+// files are sent as the warning messages, and the rest are sent.
+func (srv *Server) source(rw io.ReadWriter, cmd *ServerCommand) error {
+	fsys := localFS(srv.FS)
+
+	c := newSource(rw, rw, ContinueOnError)
+	c.warn = true
+	if err := c.start(); err != nil {
+		return err
+	}
+
+	p := &pusher{c: c, fs: fsys, perm: cmd.Preserve, symlink: SymlinkFollow}
+	for _, name := range cmd.Paths {
+		if !cmd.Recursive {
+			if info, err := statFile(fsys, name); err == nil && info.IsDir() {
+				if err = c.fail(localError("open", name, errNotRegular)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err := p.push(name, filepath.Base(name)); err != nil {
+			return err
+		}
+	}
+	return c.err()
+}
+
+// errNotRegular is the error of a directory sent without -r.
+var errNotRegular = errors.New("not a regular file")
+
+// serverHandler write the entries received by Server to FS. The
+// directories are rejected, if not recursive.
+type serverHandler struct {
+	*fileWriter
+	recursive bool
+}
+
+func (h *serverHandler) handle(hdr *Header, body io.Reader) error {
+	if hdr.Type == TypeDir && !h.recursive {
+		return &ProtocolError{Line: hdr.line(), Msg: "received directory without -r"}
+	}
+	return h.fileWriter.handle(hdr, body)
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// runServer connect Server and the client side run by client, and return
+// the errors of both sides.
+func runServer(srv *Server, cmd *ServerCommand, client func(r io.Reader, w io.Writer) error) (serr, cerr error) {
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		err := srv.Serve(struct {
+			io.Reader
+			io.Writer
+		}{toServer, fromServer}, cmd)
+		fromServer.Close()
+		toServer.Close()
+		done <- err
+	}()
+
+	cerr = client(toClient, fromClient)
+	fromClient.Close()
+	toClient.Close()
+	return <-done, cerr
+}
+
+// putClient return the client that send fromPaths with s, as PutFile.
+func putClient(s *SCPClient, fromPaths []string) func(r io.Reader, w io.Writer) error {
+	return func(r io.Reader, w io.Writer) error {
+		c := newSource(r, w, s.ErrorPolicy)
+		if err := c.start(); err != nil {
+			return err
+		}
+		if err := s.putFiles(fromPaths)(c); err != nil {
+			return err
+		}
+		return c.err()
+	}
+}
+
+// getClient return the client that receive the files to toPath, as GetFile.
+func getClient(toPath string) func(r io.Reader, w io.Writer) error {
+	return func(r io.Reader, w io.Writer) error {
+		return newSink(r, w).run(&fileWriter{path: toPath, perm: true})
+	}
+}
+
+func mustParse(t *testing.T, command string) *ServerCommand {
+	cmd, err := ParseServerCommand(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestParseServerCommand(t *testing.T) {
+	client := &SCPClient{Permission: true, Env: []string{"LC_ALL=C"}}
+	sinkCmd, _ := client.sinkCommand("~/my dir", false)
+	sourceCmd, _ := client.sourceCommand([]string{"-a", "b'c", "~"})
+
+	tests := []struct {
+		command string
+		want    ServerCommand
+	}{
+		{
+			command: "scp -t /tmp/x",
+			want:    ServerCommand{Sink: true, Paths: []string{"/tmp/x"}},
+		},
+		{
+			command: "/usr/bin/scp -v -r -d -t -- 'a b'",
+			want:    ServerCommand{Sink: true, Recursive: true, TargetDir: true, Paths: []string{"a b"}},
+		},
+		{
+			command: sinkCmd,
+			want:    ServerCommand{Sink: true, Recursive: true, Preserve: true, Paths: []string{"./my dir"}},
+		},
+		{
+			command: sourceCmd,
+			want:    ServerCommand{Source: true, Recursive: true, Preserve: true, Paths: []string{"-a", "b'c", "."}},
+		},
+		{
+			command: "scp -f - \"x y\"",
+			want:    ServerCommand{Source: true, Paths: []string{"-", "x y"}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseServerCommand(test.command)
+		if err != nil {
+			t.Errorf("%s: %v", test.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.command, *got, test.want)
+		}
+	}
+
+	for _, command := range []string{
+		"",
+		"ls -l",
+		"scp",
+		"scp a",
+		"scp -x -t a",
+		"scp -t -f a",
+		"scp -t a b",
+		"scp -f",
+		"scp -f $(id)",
+		"scp -f a; rm -rf /",
+	} {
+		if _, err := ParseServerCommand(command); err == nil {
+			t.Errorf("%q is parsed", command)
+		}
+	}
+}
+
+func TestServerSink(t *testing.T) {
+	src := makeTree(t, map[string]string{
+		"top/a":     "aaa",
+		"top/sub/b": "bb",
+		"f":         "f",
+	})
+	defer os.RemoveAll(src)
+	dst := makeTree(t, nil)
+	defer os.RemoveAll(dst)
+	os.Chmod(filepath.Join(src, "top/a"), 0640)
+
+	s := &SCPClient{Permission: true}
+	command, _ := s.sinkCommand(dst, true)
+	srv := &Server{}
+
+	serr, cerr := runServer(srv, mustParse(t, command), putClient(s, []string{filepath.Join(src, "top"), filepath.Join(src, "f")}))
+	if serr != nil || cerr != nil {
+		t.Fatalf("server: %v, client: %v", serr, cerr)
+	}
+
+	for path, want := range map[string]string{"top/a": "aaa", "top/sub/b": "bb", "f": "f"} {
+		data, err := ioutil.ReadFile(filepath.Join(dst, path))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dst, "top/a")); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("mode is not preserved: %v, %v", info, err)
+	}
+}
+
+func TestServerSinkErrors(t *testing.T) {
+	src := makeTree(t, map[string]string{"top/a": "aaa"})
+	defer os.RemoveAll(src)
+	dst := makeTree(t, map[string]string{"file": "x"})
+	defer os.RemoveAll(dst)
+	srv := &Server{}
+	s := &SCPClient{ErrorPolicy: ContinueOnError}
+
+	// the target of -d is not a directory
+	file := filepath.Join(dst, "file")
+	cmd := &ServerCommand{Sink: true, TargetDir: true, Paths: []string{file}}
+	serr, cerr := runServer(srv, cmd, putClient(s, []string{filepath.Join(src, "top/a")}))
+	if lerr, ok := serr.(*LocalIOError); !ok || lerr.Path != file {
+		t.Errorf("server err = %v, want *LocalIOError", serr)
+	}
+	if rerr, ok := cerr.(*RemoteError); !ok || rerr.Severity != SeverityFatal {
+		t.Errorf("client err = %v, want fatal *RemoteError", cerr)
+	}
+
+	// directory without -r
+	cmd = &ServerCommand{Sink: true, Paths: []string{dst}}
+	serr, _ = runServer(srv, cmd, putClient(s, []string{filepath.Join(src, "top")}))
+	if _, ok := serr.(*ProtocolError); !ok {
+		t.Errorf("server err = %v, want *ProtocolError", serr)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "top")); !os.IsNotExist(err) {
+		t.Errorf("directory is created without -r: %v", err)
+	}
+}
+
+func TestServerSinkRejectedDir(t *testing.T) {
+	src := makeTree(t, map[string]string{
+		"top/a":       "aaa",
+		"top/sub/b":   "bb",
+		"top/sub/c/d": "d",
+		"top/z":       "zzz",
+	})
+	defer os.RemoveAll(src)
+
+	// top/sub can not be created, as a file exists
+	m := &MemFS{}
+	m.MkdirAll("/dst/top", 0755)
+	m.WriteFile("/dst/top/sub", []byte("x"), 0644)
+	srv := &Server{FS: m}
+	s := &SCPClient{ErrorPolicy: ContinueOnError}
+
+	cmd := &ServerCommand{Sink: true, Recursive: true, Paths: []string{"/dst"}}
+	serr, cerr := runServer(srv, cmd, putClient(s, []string{filepath.Join(src, "top")}))
+	if lerr, ok := serr.(*LocalIOError); !ok || lerr.Path != "/dst/top/sub" {
+		t.Errorf("server err = %v, want *LocalIOError of /dst/top/sub", serr)
+	}
+	if rerr, ok := cerr.(*RemoteError); !ok || rerr.Severity != SeverityWarning || rerr.Path != "top/sub" {
+		t.Errorf("client err = %v, want warning of top/sub", cerr)
+	}
+
+	// the files after the rejected directory are written
+	for name, want := range map[string]string{"/dst/top/a": "aaa", "/dst/top/z": "zzz", "/dst/top/sub": "x"} {
+		if data, err := m.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+}
+
+func TestServerSource(t *testing.T) {
+	src := makeTree(t, map[string]string{
+		"top/a":     "aaa",
+		"top/sub/b": "bb",
+		"f":         "f",
+	})
+	defer os.RemoveAll(src)
+	dst := makeTree(t, nil)
+	defer os.RemoveAll(dst)
+
+	s := &SCPClient{}
+	missing := filepath.Join(src, "missing")
+	command, _ := s.sourceCommand([]string{
+		filepath.Join(src, "top"),
+		missing,
+		filepath.Join(src, "f"),
+	})
+	srv := &Server{}
+
+	serr, cerr := runServer(srv, mustParse(t, command), getClient(dst+"/"))
+	if lerr, ok := serr.(*LocalIOError); !ok || lerr.Path != missing {
+		t.Errorf("server err = %v, want *LocalIOError of missing", serr)
+	}
+	if rerr, ok := cerr.(*RemoteError); !ok || rerr.Severity != SeverityWarning {
+		t.Errorf("client err = %v, want warning *RemoteError", cerr)
+	}
+
+	// the files after the error are sent
+	for path, want := range map[string]string{"top/a": "aaa", "top/sub/b": "bb", "f": "f"} {
+		data, err := ioutil.ReadFile(filepath.Join(dst, path))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+		}
+	}
+}
+
+func TestServerSourceNotRecursive(t *testing.T) {
+	src := makeTree(t, map[string]string{
+		"top/a": "aaa",
+		"f":     "f",
+	})
+	defer os.RemoveAll(src)
+	dst := makeTree(t, nil)
+	defer os.RemoveAll(dst)
+
+	top := filepath.Join(src, "top")
+	cmd := &ServerCommand{Source: true, Paths: []string{top, filepath.Join(src, "f")}}
+	serr, cerr := runServer(&Server{}, cmd, getClient(dst+"/"))
+	if lerr, ok := serr.(*LocalIOError); !ok || lerr.Err != errNotRegular {
+		t.Errorf("server err = %v, want %v", serr, errNotRegular)
+	}
+	if rerr, ok := cerr.(*RemoteError); !ok || rerr.Message != "scp: "+top+": not a regular file" {
+		t.Errorf("client err = %v, want warning of %s", cerr, top)
+	}
+
+	if _, err := os.Lstat(filepath.Join(dst, "top")); !os.IsNotExist(err) {
+		t.Errorf("directory is sent without -r: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dst, "f")); err != nil || string(data) != "f" {
+		t.Errorf("f = %q, %v", data, err)
+	}
+}
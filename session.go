@@ -0,0 +1,259 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newSession return the session used for a transfer.
+func (s *SCPClient) newSession() (session *ssh.Session, err error) {
+	session = s.Session
+	if s.Connection != nil {
+		session, err = s.Connection.NewSession()
+	}
+	return
+}
+
+// output run cmd on a new session, and return its stdout. If ctx is done
+// before the end, the session is closed and ctx.Err() is returned.
+func (s *SCPClient) output(ctx context.Context, cmd string) ([]byte, error) {
+	if s.Connection == nil {
+		return nil, ErrNoConnection
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session, err := s.Connection.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	out, err := session.Output(cmd)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return out, err
+}
+
+// exitStatus return the exit status of the remote command in err, or -1
+// if err is not an exit status.
+func exitStatus(err error) int {
+	if eerr, ok := err.(*ssh.ExitError); ok {
+		return eerr.ExitStatus()
+	}
+	return -1
+}
+
+// run start scpCmd on remote, and run proto with the stdout and stdin of
+// it. If ctx is done before the end, the session is closed and ctx.Err()
+// is returned. If IdleTimeout passes without any bytes, ErrIdleTimeout is
+// returned.
+func (s *SCPClient) run(ctx context.Context, scpCmd string, proto func(r io.Reader, w io.Writer) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	session, err := s.newSession()
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return
+	}
+
+	// Run scp
+	if err = session.Start(scpCmd); err != nil {
+		return
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t := newTransport(s.RateLimit.reader(ctx, stdout), s.RateLimit.writer(ctx, stdin))
+	idle := t.watchIdle(ctx, cancel, s.IdleTimeout)
+
+	// stop proto and close session, when ctx is done
+	go func() {
+		<-ctx.Done()
+		t.abort(ctx.Err())
+		session.Close()
+	}()
+
+	err = proto(t.r, t.w)
+	t.closeWrite()
+
+	// read the rest, so that remote can exit
+	io.Copy(ioutil.Discard, t.r)
+
+	// do not wait for the remote, that does not answer
+	waitc := make(chan error, 1)
+	go func() {
+		waitc <- session.Wait()
+	}()
+
+	var werr error
+	select {
+	case werr = <-waitc:
+	case <-ctx.Done():
+	}
+
+	switch {
+	case atomic.LoadInt32(idle) != 0:
+		return ErrIdleTimeout
+	case parent.Err() != nil:
+		return parent.Err()
+	case err == nil:
+		err = werr
+	}
+
+	return
+}
+
+// runSink run scpCmd(`scp -f`) on remote, and pass the received records to h.
+func (s *SCPClient) runSink(ctx context.Context, scpCmd string, h sinkHandler) error {
+	return s.run(ctx, scpCmd, func(r io.Reader, w io.Writer) error {
+		k := newSink(r, w)
+		k.progress = s.progress()
+		k.filter = s.Filter
+		return k.run(h)
+	})
+}
+
+// runSource run scpCmd(`scp -t`) on remote, and send records with send.
+// If DryRun, the records are only planned.
+func (s *SCPClient) runSource(ctx context.Context, scpCmd string, send func(c *source) error) error {
+	if s.DryRun {
+		_, err := s.planSource(ctx, send)
+		return err
+	}
+
+	return s.run(ctx, scpCmd, func(r io.Reader, w io.Writer) (err error) {
+		c := newSource(r, w, s.ErrorPolicy)
+		c.progress = s.progress()
+		if err = c.start(); err == nil {
+			err = send(c)
+		}
+		return c.errs.with(err)
+	})
+}
+
+// transport connect the protocol with the stdout and stdin of session,
+// through pipes. Unlike the session, the pipes can be closed at any time
+// to unblock the protocol, even if the remote does not answer.
+type transport struct {
+	active int64 // unix nano time of the last activity, accessed atomically
+
+	r  *io.PipeReader // read by protocol
+	rw *io.PipeWriter
+	w  *io.PipeWriter // written by protocol
+	wr *io.PipeReader
+}
+
+func newTransport(stdout io.Reader, stdin io.WriteCloser) *transport {
+	t := &transport{active: time.Now().UnixNano()}
+	t.r, t.rw = io.Pipe()
+	t.wr, t.w = io.Pipe()
+
+	go func() {
+		_, err := io.Copy(t.rw, activityReader{r: stdout, t: t})
+		t.rw.CloseWithError(err)
+	}()
+
+	go func() {
+		_, err := io.Copy(stdin, activityReader{r: t.wr, t: t})
+		stdin.Close()
+		t.wr.CloseWithError(err)
+	}()
+
+	return t
+}
+
+// closeWrite close stdin, after the written data is sent.
+func (t *transport) closeWrite() {
+	t.w.Close()
+}
+
+// abort make the reads and writes of the protocol fail with err.
+func (t *transport) abort(err error) {
+	t.rw.CloseWithError(err)
+	t.wr.CloseWithError(err)
+}
+
+// watchIdle call cancel, when no bytes moved for timeout. The returned flag
+// is set to 1 at the time.
+func (t *transport) watchIdle(ctx context.Context, cancel context.CancelFunc, timeout time.Duration) *int32 {
+	fired := new(int32)
+	if timeout <= 0 {
+		return fired
+	}
+
+	interval := timeout / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				last := time.Unix(0, atomic.LoadInt64(&t.active))
+				if now.Sub(last) >= timeout {
+					atomic.StoreInt32(fired, 1)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return fired
+}
+
+// activityReader record the time of reads to the transport.
+type activityReader struct {
+	r io.Reader
+	t *transport
+}
+
+func (a activityReader) Read(p []byte) (n int, err error) {
+	n, err = a.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&a.t.active, time.Now().UnixNano())
+	}
+	return
+}
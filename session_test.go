@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nopWriteCloser is the stdin of a remote, that never reads.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestTransportAbort(t *testing.T) {
+	stdout, _ := io.Pipe() // remote never answers
+	tr := newTransport(stdout, nopWriteCloser{ioutil.Discard})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tr.r.Read(make([]byte, 1))
+		errc <- err
+	}()
+
+	tr.abort(context.Canceled)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read is not unblocked by abort")
+	}
+}
+
+func TestTransportIdle(t *testing.T) {
+	stdout, remote := io.Pipe()
+	tr := newTransport(stdout, nopWriteCloser{ioutil.Discard})
+	go io.Copy(ioutil.Discard, tr.r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fired := tr.watchIdle(ctx, cancel, 100*time.Millisecond)
+
+	// keep bytes moving longer than the timeout
+	for i := 0; i < 10; i++ {
+		remote.Write([]byte("x"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	if atomic.LoadInt32(fired) != 0 {
+		t.Fatal("idle timeout fired while bytes are moving")
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle timeout did not fire")
+	}
+	if atomic.LoadInt32(fired) == 0 {
+		t.Error("idle flag is not set")
+	}
+	remote.Close()
+}
@@ -0,0 +1,523 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// sinkHandler receive the entries read by sink.
+type sinkHandler interface {
+	// handle is called for each file, directory and end of directory. The
+	// time record is set to the times of the next header. For files, body
+	// reads the file data, and is limited to the file size.
+	handle(hdr *Header, body io.Reader) error
+}
+
+// fileFinisher is implemented by the sinkHandler, that needs the status of
+// the file sent by the source after the data.
+type fileFinisher interface {
+	// finish is called after each file passed to handle. err is the reason,
+	// if the file was not received completely or handle failed.
+	finish(err error) error
+}
+
+// finishFile call finish of h, if implemented.
+func finishFile(h sinkHandler, err error) error {
+	if f, isFinisher := h.(fileFinisher); isFinisher {
+		return f.finish(err)
+	}
+	return nil
+}
+
+// sink is the receiving side of the scp protocol. It reads records from
+// the remote `scp -f`, and answers exactly one ack to each of them.
+type sink struct {
+	r    *bufio.Reader
+	w    io.Writer
+	dirs []string // stack of received directory names
+	skip int      // depth in the excluded directory
+
+	progress *progress
+	filter   *Filter
+}
+
+func newSink(r io.Reader, w io.Writer) *sink {
+	return &sink{r: bufio.NewReader(r), w: w}
+}
+
+// ack send success response to the remote.
+func (k *sink) ack() error {
+	_, err := k.w.Write([]byte{respOK})
+	return err
+}
+
+// reject send warning message to the remote.
+func (k *sink) reject(err error) error {
+	msg := strings.Replace(err.Error(), "\n", " ", -1)
+	_, werr := fmt.Fprintf(k.w, "%cscp: %s\n", respWarning, msg)
+	return werr
+}
+
+// run read all records from remote, until remote close the stream.
+// Warning messages from the remote and failed files and directories are
+// not abort the transfer, and they are returned after the end (as
+// *MultiError, if more than one). They are also returned with the error
+// aborting the transfer.
+func (k *sink) run(h sinkHandler) (err error) {
+	var errs errorList
+	defer func() {
+		err = errs.with(err)
+	}()
+
+	// start transfer
+	if err = k.ack(); err != nil {
+		return err
+	}
+
+	var times *record
+	for {
+		b, err := k.r.ReadByte()
+		if err == io.EOF {
+			if len(k.dirs) != 0 || times != nil {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// warning or fatal message from remote
+		if b == respWarning || b == respFatal {
+			msg, err := readLine(k.r)
+			if err != nil {
+				return err
+			}
+
+			rerr := &RemoteError{Severity: Severity(b), Message: msg}
+			if rerr.Severity == SeverityFatal {
+				return rerr
+			}
+			errs.add(rerr)
+			continue
+		}
+
+		k.r.UnreadByte()
+		line, err := readLine(k.r)
+		if err != nil {
+			return err
+		}
+
+		rec, err := parseRecord(line)
+		if err != nil {
+			k.reject(err)
+			return err
+		}
+
+		if rec.typ == 'T' {
+			if times != nil {
+				err = &ProtocolError{Line: line, Msg: "duplicate time line"}
+				k.reject(err)
+				return err
+			}
+			times = &rec
+			if err = k.ack(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		hdr := newHeader(rec)
+		if times != nil {
+			hdr.ModTime = times.mtime
+			hdr.AccessTime = times.atime
+			times = nil
+		}
+
+		switch rec.typ {
+		case 'C':
+			if err = k.ack(); err != nil {
+				return err
+			}
+
+			// excluded file is read and discarded, and not finished
+			var fp *fileProgress
+			var herr error
+			handled := false
+			finish := func(err error) error {
+				if !handled {
+					return nil
+				}
+				return finishFile(h, err)
+			}
+			body := &io.LimitedReader{R: k.r, N: rec.size}
+			if rel := path.Join(append(k.dirs, hdr.Name)...); k.skip == 0 && k.filter.Match(rel, false) {
+				fp = k.progress.file(rel, rec.size)
+				fp.start()
+				handled = true
+				herr = h.handle(hdr, progressReader{r: body, f: fp})
+			}
+
+			// drain the data not read by handler
+			if _, err = io.Copy(ioutil.Discard, body); err == nil && body.N > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				finish(err)
+				fp.finish(err)
+				return err
+			}
+
+			// status of the source, after file data
+			if err = readResponse(k.r); err != nil {
+				finish(err)
+				fp.finish(err)
+				if rerr, ok := err.(*RemoteError); ok && rerr.Severity != SeverityFatal {
+					errs.add(rerr)
+					err = k.ack()
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if herr == nil {
+				herr = finish(nil)
+			} else {
+				finish(herr)
+			}
+
+			fp.finish(herr)
+			if herr != nil {
+				errs.add(herr)
+				err = k.reject(herr)
+			} else {
+				err = k.ack()
+			}
+
+		case 'E':
+			if len(k.dirs) == 0 || hdr.hasTimes() {
+				err = &ProtocolError{Line: line, Msg: "unexpected end line"}
+				k.reject(err)
+				return err
+			}
+			k.dirs = k.dirs[:len(k.dirs)-1]
+			fallthrough
+
+		default:
+			if rec.typ == 'D' {
+				k.dirs = append(k.dirs, hdr.Name)
+				if k.skip > 0 || !k.filter.Match(path.Join(k.dirs...), true) {
+					k.skip++
+				}
+			}
+
+			// the contents of excluded directory are acked, and not handled
+			if k.skip > 0 {
+				if rec.typ == 'E' {
+					k.skip--
+				}
+				err = k.ack()
+				break
+			}
+
+			herr := h.handle(hdr, nil)
+			if _, ok := herr.(*ProtocolError); ok || (herr != nil && rec.typ != 'D') {
+				k.reject(herr)
+				return herr
+			}
+
+			// the source skip the contents of the rejected directory, and
+			// its end line, as OpenSSH scp
+			if herr != nil {
+				k.dirs = k.dirs[:len(k.dirs)-1]
+				errs.add(herr)
+				err = k.reject(herr)
+				break
+			}
+			err = k.ack()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fileWriter write the entries received by sink to local files.
+type fileWriter struct {
+	fs     FS // OSFS if nil
+	path   string
+	perm   bool
+	atomic bool
+	dirs   []*receivedDir // stack of received directories
+
+	// whether path is an existing directory, checked at the first entry
+	checked, isDir bool
+
+	// temporary file written by atomic, renamed to dest by finish
+	temp, dest string
+}
+
+// fsys return the file system of f.
+func (f *fileWriter) fsys() FS {
+	return localFS(f.fs)
+}
+
+// receivedDir is the directory created by fileWriter.
+type receivedDir struct {
+	path string
+	hdr  *Header
+}
+
+// setTimes set the times of hdr to path, if perm.
+func (f *fileWriter) setTimes(path string, hdr *Header) error {
+	if !f.perm || !hdr.hasTimes() {
+		return nil
+	}
+
+	atime, mtime := hdr.times()
+	return localError("chtimes", path, f.fsys().Chtimes(path, atime, mtime))
+}
+
+// target return the local path of the file or directory of hdr. As
+// OpenSSH scp, the top entries are put into path if it is an existing
+// directory at the first entry, and are written as path otherwise.
+func (f *fileWriter) target(hdr *Header) string {
+	if len(f.dirs) > 0 {
+		return filepath.Join(f.dirs[len(f.dirs)-1].path, hdr.Name)
+	}
+
+	if !f.checked {
+		info, err := statFile(f.fsys(), f.path)
+		f.isDir = err == nil && info.IsDir()
+		f.checked = true
+	}
+	if f.isDir {
+		// the directory "." is path itself
+		return filepath.Join(f.path, hdr.Name)
+	}
+	return f.path
+}
+
+// notDir report whether a top entry is written as path, though path end
+// with a separator. It is called after target.
+func (f *fileWriter) notDir() bool {
+	if len(f.dirs) > 0 || f.isDir || f.path == "" {
+		return false
+	}
+	return os.IsPathSeparator(f.path[len(f.path)-1])
+}
+
+func (f *fileWriter) handle(hdr *Header, body io.Reader) error {
+	switch hdr.Type {
+	case TypeFile:
+		scpPath := f.target(hdr)
+		if f.notDir() {
+			return localError("create", scpPath, syscall.EISDIR)
+		}
+
+		// set permission. Without perm, the mode of an existing file is
+		// kept, as OpenSSH scp.
+		mode := hdr.Mode
+		keep := false
+		if !f.perm {
+			mode = 0644
+			if info, err := statFile(f.fsys(), scpPath); err == nil && info.Mode().IsRegular() {
+				mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+				keep = true
+			}
+		}
+
+		if f.atomic {
+			return f.writeTemp(scpPath, mode, hdr, body)
+		}
+
+		file, err := f.fsys().Create(scpPath, mode)
+		if err != nil {
+			return localError("create", scpPath, err)
+		}
+
+		// the body is short, if the remote is gone in the middle
+		n, err := io.Copy(errWriter{w: file}, body)
+		if err == nil && n < hdr.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		cerr := file.Close()
+		if err != nil {
+			// do not leave the partial file
+			removeFile(f.fsys(), scpPath)
+		}
+		if werr, ok := err.(*writeError); ok {
+			return localError("write", scpPath, werr.err)
+		} else if err != nil {
+			return err
+		}
+		if cerr != nil {
+			return localError("close", scpPath, cerr)
+		}
+
+		if !keep {
+			if err = f.fsys().Chmod(scpPath, mode); err != nil {
+				return localError("chmod", scpPath, err)
+			}
+		}
+		return f.setTimes(scpPath, hdr)
+
+	case TypeDir:
+		dir := f.target(hdr)
+
+		mode := hdr.Mode
+		if !f.perm {
+			mode = 0755
+		}
+
+		if err := f.fsys().Mkdir(dir, mode); err != nil {
+			if !errors.Is(err, os.ErrExist) {
+				return localError("mkdir", dir, err)
+			}
+			if info, err := statFile(f.fsys(), dir); err == nil && !info.IsDir() {
+				return localError("mkdir", dir, syscall.ENOTDIR)
+			}
+
+			// the mode of an existing directory is kept without perm
+			if f.perm {
+				if err = f.fsys().Chmod(dir, mode); err != nil {
+					return localError("chmod", dir, err)
+				}
+			}
+		}
+		f.dirs = append(f.dirs, &receivedDir{path: dir, hdr: hdr})
+
+	case TypeEnd:
+		// set times after the contents are written
+		dir := f.dirs[len(f.dirs)-1]
+		f.dirs = f.dirs[:len(f.dirs)-1]
+		return f.setTimes(dir.path, dir.hdr)
+	}
+
+	return nil
+}
+
+// writeTemp write the file to a temporary file next to scpPath, with the
+// mode and times. It is renamed to scpPath by finish.
+func (f *fileWriter) writeTemp(scpPath string, mode os.FileMode, hdr *Header, body io.Reader) (err error) {
+	temp, file, err := createTemp(f.fsys(), scpPath)
+	if err != nil {
+		return localError("create", scpPath, err)
+	}
+
+	defer func() {
+		if err != nil {
+			file.Close()
+			removeFile(f.fsys(), temp)
+		}
+	}()
+
+	n, err := io.Copy(errWriter{w: file}, body)
+	if err == nil && n < hdr.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		if werr, ok := err.(*writeError); ok {
+			return localError("write", temp, werr.err)
+		}
+		return err
+	}
+	if syncer, ok := file.(interface{ Sync() error }); ok {
+		if err = syncer.Sync(); err != nil {
+			return localError("sync", temp, err)
+		}
+	}
+	if err = file.Close(); err != nil {
+		return localError("close", temp, err)
+	}
+	if err = f.fsys().Chmod(temp, mode); err != nil {
+		return localError("chmod", temp, err)
+	}
+	if err = f.setTimes(temp, hdr); err != nil {
+		return err
+	}
+
+	f.temp, f.dest = temp, scpPath
+	return nil
+}
+
+// finish rename the temporary file of atomic to the destination, or
+// remove it if the file was not received completely.
+func (f *fileWriter) finish(ferr error) error {
+	if f.temp == "" {
+		return nil
+	}
+
+	temp, dest := f.temp, f.dest
+	f.temp, f.dest = "", ""
+
+	if ferr != nil {
+		removeFile(f.fsys(), temp)
+		return nil
+	}
+	if err := f.fsys().Rename(temp, dest); err != nil {
+		removeFile(f.fsys(), temp)
+		return localError("rename", dest, err)
+	}
+	return nil
+}
+
+// rawWriter write the entries received by sink as scp format data. The
+// status of each file is written after the data, by finish.
+type rawWriter struct {
+	w      io.Writer
+	inFile bool // the data of a file is written, and not the status
+}
+
+func (r *rawWriter) handle(hdr *Header, body io.Reader) error {
+	line := hdr.line() + "\n"
+	if hdr.hasTimes() {
+		line = hdr.timeLine() + "\n" + line
+	}
+	if _, err := io.WriteString(r.w, line); err != nil {
+		return err
+	}
+
+	if hdr.Type == TypeFile {
+		r.inFile = true
+		if _, err := io.Copy(r.w, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish write the status of the file. The broken file is marked with a
+// warning message, as the remote `scp -f` does.
+func (r *rawWriter) finish(ferr error) error {
+	if !r.inFile {
+		return nil
+	}
+	r.inFile = false
+
+	if ferr == nil {
+		_, err := r.w.Write([]byte{respOK})
+		return err
+	}
+
+	msg := ferr.Error()
+	if rerr, ok := ferr.(*RemoteError); ok {
+		msg = rerr.Message
+	}
+	_, err := fmt.Fprintf(r.w, "%c%s\n", respWarning, strings.Replace(msg, "\n", " ", -1))
+	return err
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSource act as remote `scp -f`. It send each message after reading
+// the expected number of acks, and count all acks received.
+type fakeSource struct {
+	msgs   []string
+	hangup bool // close without waiting the acks of last message
+	acks   int
+	err    error
+}
+
+func (f *fakeSource) serve(r io.Reader, w io.WriteCloser) {
+	defer w.Close()
+
+	ack := make([]byte, 1)
+	readAck := func() bool {
+		if _, err := io.ReadFull(r, ack); err != nil {
+			f.err = err
+			return false
+		}
+		if ack[0] != respOK {
+			f.err = fmt.Errorf("unexpected ack %#x", ack[0])
+			return false
+		}
+		f.acks++
+		return true
+	}
+
+	// start ack
+	if !readAck() {
+		return
+	}
+
+	for i, msg := range f.msgs {
+		if _, err := io.WriteString(w, msg); err != nil {
+			f.err = err
+			return
+		}
+
+		if f.hangup && i == len(f.msgs)-1 {
+			w.Close()
+			io.Copy(ioutil.Discard, r)
+			return
+		}
+
+		switch {
+		case msg[0] == respWarning || msg[0] == respFatal:
+			// no ack for error message
+		case msg[0] == 'C':
+			// header ack, and ack after data
+			if !readAck() || !readAck() {
+				return
+			}
+		default:
+			if !readAck() {
+				return
+			}
+		}
+	}
+}
+
+// runFakeSource connect sink and fakeSource, and run sink with h.
+func runFakeSource(f *fakeSource, h sinkHandler) error {
+	return runFakeSourceWith(f, h, func(k *sink) {})
+}
+
+// runFakeSourceWith is runFakeSource, with the sink set up by setup.
+func runFakeSourceWith(f *fakeSource, h sinkHandler, setup func(k *sink)) error {
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		f.serve(toSource, fromSource)
+		toSource.Close()
+		close(done)
+	}()
+
+	k := newSink(toSink, fromSink)
+	setup(k)
+	err := k.run(h)
+	toSink.Close()
+	fromSink.Close()
+	<-done
+	return err
+}
+
+func fileMsg(name, data string) string {
+	return fmt.Sprintf("C0644 %d %s\n%s\x00", len(data), name, data)
+}
+
+func TestSinkManyFiles(t *testing.T) {
+	const n = 5000
+
+	f := &fakeSource{msgs: []string{"D0755 0 dir\n"}}
+	want := new(bytes.Buffer)
+	want.WriteString("D0755 0 dir\n")
+	for i := 0; i < n; i++ {
+		msg := fileMsg(fmt.Sprintf("f%d", i), strings.Repeat("x", i%7))
+		f.msgs = append(f.msgs, msg)
+		want.WriteString(msg)
+	}
+	f.msgs = append(f.msgs, "E\n")
+	want.WriteString("E\n")
+
+	got := new(bytes.Buffer)
+	if err := runFakeSource(f, &rawWriter{w: got}); err != nil {
+		t.Fatal(err)
+	}
+	if f.err != nil {
+		t.Fatal(f.err)
+	}
+
+	// start + D + 2 * C + E
+	if wantAcks := 1 + 1 + 2*n + 1; f.acks != wantAcks {
+		t.Errorf("acks = %d, want %d", f.acks, wantAcks)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("raw data mismatch")
+	}
+}
+
+func TestSinkWriteFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scplib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &fakeSource{msgs: []string{
+		"T1500000000 0 1500000000 0\n",
+		"D0700 0 sub\n",
+		fileMsg("a.txt", "hello"),
+		"D0755 0 deep\n",
+		fileMsg("b.txt", "world\n"),
+		"E\n",
+		"E\n",
+	}}
+	if err := runFakeSource(f, &fileWriter{path: dir, perm: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		"sub/a.txt":      "hello",
+		"sub/deep/b.txt": "world\n",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("sub mode = %v, want 0700", info.Mode().Perm())
+	}
+
+	// times of the directory are set after its contents
+	if !info.ModTime().Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("sub mtime = %v", info.ModTime())
+	}
+}
+
+func TestSinkDot(t *testing.T) {
+	m := &MemFS{}
+	m.MkdirAll("dest", 0755)
+
+	// the contents of "." are put into the existing directory, or the new
+	// directory of the target
+	for _, target := range []string{"dest", "new"} {
+		f := &fakeSource{msgs: []string{
+			"D0755 0 .\n",
+			fileMsg("a", "aaa"),
+			"E\n",
+		}}
+		if err := runFakeSource(f, &fileWriter{fs: m, path: target}); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if data, err := m.ReadFile(target + "/a"); err != nil || string(data) != "aaa" {
+			t.Errorf("%s/a = %q, %v", target, data, err)
+		}
+	}
+
+	// the file "." is invalid
+	f := &fakeSource{msgs: []string{fileMsg(".", "x")}}
+	if err := runFakeSource(f, &fileWriter{fs: m, path: "dest"}); err == nil {
+		t.Error("file . is received")
+	}
+}
+
+func TestSinkAtomic(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "old a", "b": "old b", "c": "old c"})
+	defer os.RemoveAll(dir)
+
+	f := &fakeSource{msgs: []string{
+		"C0644 5 a\nbroke\x01scp: a: read error\n",
+		"T1500000000 0 1500000000 0\n",
+		"C0640 5 b\nnew b\x00",
+		"C0644 5 c\nne",
+	}, hangup: true}
+	err := runFakeSource(f, &fileWriter{path: dir + "/", perm: true, atomic: true})
+	merr, ok := err.(*MultiError)
+	if !ok || len(merr.Errors) != 2 || merr.Errors[1] != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want the warning of a and %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// failed files are not changed
+	for name, want := range map[string]string{"a": "old a", "b": "new b", "c": "old c"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("b mode = %v, mtime = %v", info.Mode().Perm(), info.ModTime())
+	}
+
+	// no temporary files are left
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		for _, entry := range entries {
+			t.Log(entry.Name())
+		}
+		t.Errorf("%d files are left, want 3", len(entries))
+	}
+}
+
+func TestSinkKeepMode(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		m := &MemFS{}
+		m.MkdirAll("/dst/top", 0700)
+		m.WriteFile("/dst/top/a", []byte("old"), 0600)
+
+		f := &fakeSource{msgs: []string{
+			"D0777 0 top\n",
+			"C0666 3 a\naaa\x00",
+			"C0666 3 b\nbbb\x00",
+			"D0777 0 sub\n",
+			"E\n",
+			"E\n",
+		}}
+		if err := runFakeSource(f, &fileWriter{fs: m, path: "/dst", atomic: atomic}); err != nil {
+			t.Fatalf("atomic %v: %v", atomic, err)
+		}
+
+		// without perm, the existing entries keep their modes
+		modes := map[string]os.FileMode{
+			"/dst/top":     os.ModeDir | 0700,
+			"/dst/top/a":   0600,
+			"/dst/top/b":   0644,
+			"/dst/top/sub": os.ModeDir | 0755,
+		}
+		for name, want := range modes {
+			if info, err := m.Lstat(name); err != nil || info.Mode() != want {
+				t.Errorf("atomic %v: %s = %v, %v, want %v", atomic, name, info, err, want)
+			}
+		}
+		if data, _ := m.ReadFile("/dst/top/a"); string(data) != "aaa" {
+			t.Errorf("atomic %v: a = %q", atomic, data)
+		}
+	}
+}
+
+func TestSinkTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scplib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	msgs := []string{
+		"T1500000000 250000 1500000100 0\n",
+		fileMsg("a", "aaa"),
+		fileMsg("b", "bbb"),
+	}
+
+	// times are applied with perm only
+	for _, perm := range []bool{true, false} {
+		sub := filepath.Join(dir, fmt.Sprint(perm)) + "/"
+		if err = os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		f := &fakeSource{msgs: msgs}
+		if err = runFakeSource(f, &fileWriter{path: sub, perm: perm}); err != nil {
+			t.Fatal(err)
+		}
+		if f.err != nil {
+			t.Fatal(f.err)
+		}
+
+		info, err := os.Stat(filepath.Join(sub, "a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.ModTime().Equal(time.Unix(1500000000, 250000000)); got != perm {
+			t.Errorf("perm %v: a mtime = %v", perm, info.ModTime())
+		}
+		if atime := fileAtime(info); perm && !atime.Equal(time.Unix(1500000100, 0)) {
+			t.Errorf("perm %v: a atime = %v", perm, atime)
+		}
+
+		// time record is only for the next file
+		info, err = os.Stat(filepath.Join(sub, "b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ModTime().Before(time.Unix(1600000000, 0)) {
+			t.Errorf("perm %v: b mtime = %v", perm, info.ModTime())
+		}
+	}
+}
+
+func TestSinkRemoteWarning(t *testing.T) {
+	f := &fakeSource{msgs: []string{
+		"\x01scp: /nofile: No such file or directory\n",
+		fileMsg("a", "data"),
+	}}
+
+	got := new(bytes.Buffer)
+	err := runFakeSource(f, &rawWriter{w: got})
+	rerr, ok := err.(*RemoteError)
+	if !ok {
+		t.Fatalf("err = %v, want *RemoteError", err)
+	}
+	if rerr.Severity != SeverityWarning || !strings.Contains(rerr.Message, "No such file") {
+		t.Errorf("unexpected remote error %+v", rerr)
+	}
+
+	// transfer continues after warning
+	if got.String() != fileMsg("a", "data") {
+		t.Errorf("data = %q", got.String())
+	}
+}
+
+func TestSinkRemoteFatal(t *testing.T) {
+	f := &fakeSource{msgs: []string{
+		"\x02scp: fatal error\n",
+		fileMsg("a", "data"),
+	}}
+
+	err := runFakeSource(f, &rawWriter{w: ioutil.Discard})
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Severity != SeverityFatal {
+		t.Fatalf("err = %v, want fatal *RemoteError", err)
+	}
+}
+
+func TestSinkTruncated(t *testing.T) {
+	f := &fakeSource{msgs: []string{"C0644 100 a\nshort"}, hangup: true}
+
+	err := runFakeSource(f, &rawWriter{w: ioutil.Discard})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// the partial file is removed, and the temporary file of atomic
+	for _, atomic := range []bool{false, true} {
+		m := &MemFS{}
+		f = &fakeSource{msgs: []string{"C0644 100 a\nshort"}, hangup: true}
+		err = runFakeSource(f, &fileWriter{fs: m, path: "a", atomic: atomic})
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("atomic %v: err = %v, want %v", atomic, err, io.ErrUnexpectedEOF)
+		}
+		if infos, _ := m.ReadDir("/"); len(infos) != 0 {
+			t.Errorf("atomic %v: partial file is left: %v", atomic, infos[0].Name())
+		}
+	}
+}
+
+func TestSinkTruncatedAfterWarning(t *testing.T) {
+	f := &fakeSource{msgs: []string{
+		"\x01scp: /nofile: No such file or directory\n",
+		"C0644 100 a\nshort",
+	}, hangup: true}
+
+	// the warning is returned with the error aborting the transfer
+	err := runFakeSource(f, &rawWriter{w: ioutil.Discard})
+	merr, ok := err.(*MultiError)
+	if !ok || len(merr.Errors) != 2 {
+		t.Fatalf("err = %v, want *MultiError of 2 errors", err)
+	}
+	if _, ok := merr.Errors[0].(*RemoteError); !ok || merr.Errors[1] != io.ErrUnexpectedEOF {
+		t.Errorf("errors = %v", merr.Errors)
+	}
+}
+
+func TestSinkInvalidLine(t *testing.T) {
+	f := &fakeSource{msgs: []string{"garbage\n"}}
+
+	if err := runFakeSource(f, &rawWriter{w: ioutil.Discard}); err == nil {
+		t.Fatal("expected error")
+	}
+}
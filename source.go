@@ -0,0 +1,301 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrorPolicy decide what to do when the remote reject a file or directory.
+type ErrorPolicy int
+
+const (
+	// AbortOnError stop the transfer at the first rejected file (default).
+	AbortOnError ErrorPolicy = iota
+
+	// ContinueOnError skip the rejected file or directory, and continue the
+	// transfer. The errors are returned after the transfer (as *MultiError,
+	// if more than one), also with the error that stopped the transfer.
+	ContinueOnError
+)
+
+// source is the sending side of the scp protocol. It writes records to the
+// remote `scp -t`, and check the response to each of them.
+type source struct {
+	r      *bufio.Reader
+	w      io.Writer
+	policy ErrorPolicy
+	dirs   []string  // stack of sent directory names
+	errs   errorList // errors skipped by ContinueOnError
+
+	progress *progress
+	plan     *Plan // if set, the records are only added to plan
+	verify   *verifier
+
+	// warn send the local errors to the remote as warning messages, as the
+	// remote `scp -f` does.
+	warn bool
+}
+
+func newSource(r io.Reader, w io.Writer, policy ErrorPolicy) *source {
+	return &source{r: bufio.NewReader(r), w: w, policy: policy}
+}
+
+// path return the remote relative path of name.
+func (c *source) path(name string) string {
+	return path.Join(append(c.dirs, name)...)
+}
+
+// fail handle the error of a file or directory. It returns err to abort the
+// transfer, or nil to skip the file by ContinueOnError.
+func (c *source) fail(err error) error {
+	if lerr, ok := err.(*LocalIOError); ok && c.warn && c.plan == nil {
+		msg := strings.Replace(lerr.Path+": "+lerr.Err.Error(), "\n", " ", -1)
+		if _, werr := fmt.Fprintf(c.w, "%cscp: %s\n", respWarning, msg); werr != nil {
+			return werr
+		}
+	}
+	return c.skip(err)
+}
+
+// skip skip the file of err by the ErrorPolicy, without warning message.
+func (c *source) skip(err error) error {
+	if c.policy == AbortOnError {
+		return err
+	}
+	c.errs.add(err)
+	return nil
+}
+
+// err return the errors skipped by ContinueOnError.
+func (c *source) err() error {
+	return c.errs.err()
+}
+
+// start read the first response, sent by remote when it is ready.
+func (c *source) start() error {
+	if c.plan != nil {
+		return nil
+	}
+	_, err := c.check("")
+	return err
+}
+
+// response read the response to the record of path. The path is set to
+// the RemoteError.
+func (c *source) response(path string) error {
+	err := readResponse(c.r)
+	if rerr, ok := err.(*RemoteError); ok {
+		rerr.Path = path
+	}
+	return err
+}
+
+// check read the response to the record of path. It returns false if the
+// remote rejected the record, and err if the transfer must be aborted.
+func (c *source) check(path string) (ok bool, err error) {
+	err = c.response(path)
+	if err == nil {
+		return true, nil
+	}
+
+	rerr, isRemote := err.(*RemoteError)
+	if !isRemote || rerr.Severity == SeverityFatal {
+		return false, err
+	}
+	return false, c.fail(rerr)
+}
+
+// header send hdr, and the time record before it if hdr has times. It
+// returns false if the remote rejected them.
+func (c *source) header(hdr *Header) (ok bool, err error) {
+	path := c.path(hdr.Name)
+
+	if hdr.hasTimes() {
+		if _, err = io.WriteString(c.w, hdr.timeLine()+"\n"); err != nil {
+			return false, err
+		}
+		if ok, err = c.check(path); !ok {
+			return ok, err
+		}
+	}
+
+	if _, err = io.WriteString(c.w, hdr.line()+"\n"); err != nil {
+		return false, err
+	}
+	return c.check(path)
+}
+
+// file send a file header and its data.
+func (c *source) file(hdr *Header, body io.Reader) error {
+	path := c.path(hdr.Name)
+	if c.plan != nil {
+		op := c.plan.add(path, hdr)
+		if f, ok := body.(interface{ Name() string }); ok {
+			op.src = f.Name()
+		}
+		return nil
+	}
+
+	if ok, err := c.header(hdr); !ok {
+		return err
+	}
+
+	fp := c.progress.file(path, hdr.Size)
+	fp.start()
+	h := c.verify.hash()
+	ferr, err := c.data(path, hdr.Size, body, fp, h)
+	if ferr == nil {
+		c.verify.add(path, h)
+	}
+	fp.finish(ferr)
+	return err
+}
+
+// data send the data of the file at path, and read the response to it.
+// The data is also written to h, if not nil. ferr is the error of the
+// file, and err is the error to abort the transfer.
+func (c *source) data(path string, size int64, body io.Reader, fp *fileProgress, h hash.Hash) (ferr, err error) {
+	var w io.Writer = progressWriter{w: c.w, f: fp}
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+
+	// send data. If the local file can not be read to the end, pad the rest
+	// and tell the remote that the file is broken.
+	n, err := io.Copy(errWriter{w: w}, io.LimitReader(body, size))
+	if err != nil {
+		if werr, isWriteErr := err.(*writeError); isWriteErr {
+			return werr.err, werr.err
+		}
+	} else if n < size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		var msg string
+		if rerr, ok := err.(*RemoteError); ok {
+			// the status of the file in scp format data is relayed
+			ferr, msg = rerr, rerr.Message
+		} else {
+			localPath := path
+			if f, ok := body.(interface{ Name() string }); ok {
+				localPath = f.Name()
+			}
+			ferr = localError("read", localPath, err)
+			msg = "scp: " + path + ": " + strings.Replace(ferr.Error(), "\n", " ", -1)
+		}
+
+		if _, err = io.CopyN(c.w, zeroReader{}, size-n); err != nil {
+			return err, err
+		}
+		if _, err = fmt.Fprintf(c.w, "%c%s\n", respWarning, msg); err != nil {
+			return err, err
+		}
+	} else if _, err = c.w.Write([]byte{respOK}); err != nil {
+		return err, err
+	}
+
+	if rerr := c.response(path); rerr != nil {
+		if r, ok := rerr.(*RemoteError); !ok || r.Severity == SeverityFatal {
+			return rerr, rerr
+		}
+		return rerr, c.fail(rerr)
+	}
+	if ferr != nil {
+		// the remote is already told
+		return ferr, c.skip(ferr)
+	}
+	return nil, nil
+}
+
+// dir send a directory header. If the remote rejected it, ok is false and
+// the contents of the directory and the end record must not be sent.
+func (c *source) dir(hdr *Header) (ok bool, err error) {
+	if c.plan != nil {
+		c.plan.add(c.path(hdr.Name), hdr)
+		ok = true
+	} else {
+		ok, err = c.header(hdr)
+	}
+	if ok {
+		c.dirs = append(c.dirs, hdr.Name)
+	}
+	return ok, err
+}
+
+// end send the end record of the current directory.
+func (c *source) end() (err error) {
+	path := c.path("")
+	c.dirs = c.dirs[:len(c.dirs)-1]
+	if c.plan != nil {
+		return nil
+	}
+
+	if _, err = io.WriteString(c.w, "E\n"); err != nil {
+		return err
+	}
+	_, err = c.check(path)
+	return err
+}
+
+// sendData read scp format data from r, and send it to the remote. The
+// contents of a directory rejected by the remote are skipped. The warning
+// messages in the data are the errors of the ErrorPolicy.
+func (c *source) sendData(r io.Reader) (err error) {
+	tr := NewReader(r)
+	skip := 0 // depth in the rejected directory
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if rerr, ok := err.(*RemoteError); ok && rerr.Severity == SeverityWarning {
+			if err = c.fail(rerr); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		switch hdr.Type {
+		case TypeFile:
+			if skip == 0 {
+				if err = c.file(hdr, tr); err != nil {
+					return err
+				}
+			}
+
+		case TypeDir:
+			if skip > 0 {
+				skip++
+				continue
+			}
+
+			ok, err := c.dir(hdr)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				skip = 1
+			}
+
+		case TypeEnd:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if err = c.end(); err != nil {
+				return err
+			}
+		}
+	}
+}
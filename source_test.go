@@ -0,0 +1,343 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSink act as remote `scp -t`. It answer each record, and reject the
+// records whose name is in reject with the given response code.
+type fakeSink struct {
+	reject map[string]byte
+	lines  []string
+	data   map[string]string
+	err    error
+}
+
+func (f *fakeSink) serve(r io.Reader, w io.WriteCloser) {
+	defer w.Close()
+	f.data = map[string]string{}
+
+	br := bufio.NewReader(r)
+	w.Write([]byte{respOK})
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				f.err = err
+			}
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		rec, err := parseRecord(line)
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.lines = append(f.lines, line)
+
+		if code, ok := f.reject[rec.name]; ok && rec.typ != 'E' {
+			fmt.Fprintf(w, "%cscp: %s: Permission denied\n", code, rec.name)
+			if code == respFatal {
+				return
+			}
+			continue
+		}
+		w.Write([]byte{respOK})
+
+		if rec.typ == 'C' {
+			buf := make([]byte, rec.size)
+			if _, err = io.ReadFull(br, buf); err != nil {
+				f.err = err
+				return
+			}
+			if err = readResponse(br); err != nil {
+				f.lines = append(f.lines, "error: "+err.Error())
+			} else {
+				f.data[rec.name] = string(buf)
+			}
+			w.Write([]byte{respOK})
+		}
+	}
+}
+
+// runFakeSink connect source and fakeSink, and run send.
+func runFakeSink(f *fakeSink, policy ErrorPolicy, send func(c *source) error) error {
+	return runFakeSinkProgress(f, policy, nil, send)
+}
+
+// runFakeSinkProgress is runFakeSink, with the progress of source.
+func runFakeSinkProgress(f *fakeSink, policy ErrorPolicy, p *progress, send func(c *source) error) error {
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		f.serve(toSink, fromSink)
+		toSink.Close()
+		close(done)
+	}()
+
+	c := newSource(toSource, fromSource, policy)
+	c.progress = p
+	err := c.start()
+	if err == nil {
+		err = send(c)
+	}
+	err = c.errs.with(err)
+	fromSource.Close()
+	toSource.Close()
+	<-done
+	return err
+}
+
+// makeTree create the files in a temporary directory, and return it.
+func makeTree(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "scplib")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, data := range files {
+		path = filepath.Join(dir, path)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSourcePushDir(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a":     "aaa",
+		"top/sub/b": "bb",
+		"top/z":     "",
+	})
+	defer os.RemoveAll(dir)
+
+	// deepest first, not to change the times of parent
+	atime, mtime := time.Unix(1500000100, 0), time.Unix(1500000000, 0)
+	for _, path := range []string{"top/a", "top/sub/b", "top/z", "top/sub", "top"} {
+		if err := os.Chtimes(filepath.Join(dir, path), atime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &fakeSink{}
+	err := runFakeSink(f, AbortOnError, func(c *source) error {
+		return (&pusher{c: c, perm: true}).push(filepath.Join(dir, "top"), "top")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.err != nil {
+		t.Fatal(f.err)
+	}
+
+	wantLines := []string{
+		"T1500000000 0 1500000100 0",
+		"D0755 0 top",
+		"T1500000000 0 1500000100 0",
+		"C0600 3 a",
+		"T1500000000 0 1500000100 0",
+		"D0755 0 sub",
+		"T1500000000 0 1500000100 0",
+		"C0600 2 b",
+		"E",
+		"T1500000000 0 1500000100 0",
+		"C0600 0 z",
+		"E",
+	}
+	if !reflect.DeepEqual(f.lines, wantLines) {
+		t.Errorf("lines = %q, want %q", f.lines, wantLines)
+	}
+
+	wantData := map[string]string{"a": "aaa", "b": "bb", "z": ""}
+	if !reflect.DeepEqual(f.data, wantData) {
+		t.Errorf("data = %q, want %q", f.data, wantData)
+	}
+}
+
+func TestSourceAbortOnError(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/sub/a": "aaa",
+		"top/sub/b": "bbb",
+		"top/sub/c": "ccc",
+	})
+	defer os.RemoveAll(dir)
+
+	f := &fakeSink{reject: map[string]byte{"b": respWarning}}
+	err := runFakeSink(f, AbortOnError, func(c *source) error {
+		return (&pusher{c: c}).push(filepath.Join(dir, "top"), "top")
+	})
+
+	rerr, ok := err.(*RemoteError)
+	if !ok {
+		t.Fatalf("err = %v, want *RemoteError", err)
+	}
+	if rerr.Path != "top/sub/b" || !strings.Contains(rerr.Message, "Permission denied") {
+		t.Errorf("unexpected remote error %+v", rerr)
+	}
+	if _, sent := f.data["c"]; sent {
+		t.Errorf("file after rejected file was sent")
+	}
+}
+
+func TestSourceContinueOnError(t *testing.T) {
+	dir := makeTree(t, map[string]string{
+		"top/a":       "aaa",
+		"top/sub/b":   "bbb",
+		"top/sub/d/c": "ccc",
+		"top/z":       "zzz",
+	})
+	defer os.RemoveAll(dir)
+
+	f := &fakeSink{reject: map[string]byte{"a": respWarning, "sub": respWarning}}
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		return (&pusher{c: c}).push(filepath.Join(dir, "top"), "top")
+	})
+
+	merr, ok := err.(*MultiError)
+	if !ok || len(merr.Errors) != 2 {
+		t.Fatalf("err = %v, want *MultiError of 2 errors", err)
+	}
+	for i, want := range []string{"top/a", "top/sub"} {
+		if rerr, ok := merr.Errors[i].(*RemoteError); !ok || rerr.Path != want {
+			t.Errorf("errors[%d] = %v, want *RemoteError of %s", i, merr.Errors[i], want)
+		}
+	}
+
+	wantLines := []string{
+		"D0755 0 top",
+		"C0644 3 a",
+		"D0755 0 sub",
+		"C0644 3 z",
+		"E",
+	}
+	if !reflect.DeepEqual(f.lines, wantLines) {
+		t.Errorf("lines = %q, want %q", f.lines, wantLines)
+	}
+}
+
+func TestSourceFatal(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "aaa", "b": "bbb"})
+	defer os.RemoveAll(dir)
+
+	f := &fakeSink{reject: map[string]byte{"a": respFatal}}
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		p := &pusher{c: c}
+		if err := p.pushFileData(filepath.Join(dir, "a"), "a"); err != nil {
+			return err
+		}
+		return p.pushFileData(filepath.Join(dir, "b"), "b")
+	})
+
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Severity != SeverityFatal {
+		t.Fatalf("err = %v, want fatal *RemoteError", err)
+	}
+}
+
+func TestSourceFatalAfterSkipped(t *testing.T) {
+	dir := makeTree(t, map[string]string{"a": "aaa", "b": "bbb", "c": "ccc"})
+	defer os.RemoveAll(dir)
+
+	// the skipped file is returned with the fatal error
+	f := &fakeSink{reject: map[string]byte{"a": respWarning, "b": respFatal}}
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		p := &pusher{c: c}
+		for _, name := range []string{"a", "b", "c"} {
+			if err := p.pushFileData(filepath.Join(dir, name), name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	merr, ok := err.(*MultiError)
+	if !ok || len(merr.Errors) != 2 {
+		t.Fatalf("err = %v, want *MultiError of 2 errors", err)
+	}
+	for i, want := range []Severity{SeverityWarning, SeverityFatal} {
+		if rerr, ok := merr.Errors[i].(*RemoteError); !ok || rerr.Severity != want {
+			t.Errorf("errors[%d] = %v, want *RemoteError of severity %v", i, merr.Errors[i], want)
+		}
+	}
+}
+
+func TestSourceSendData(t *testing.T) {
+	data := "D0755 0 top\n" +
+		"C0644 3 a\naaa\x00" +
+		"D0700 0 skip\n" +
+		"C0644 3 b\nbbb\x00" +
+		"D0700 0 deep\n" +
+		"E\n" +
+		"E\n" +
+		"T1500000000 0 1500000000 0\n" +
+		"C0600 3 c\nccc\x00" +
+		"E\n"
+
+	f := &fakeSink{reject: map[string]byte{"skip": respWarning}}
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		return c.sendData(bytes.NewBufferString(data))
+	})
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Path != "top/skip" {
+		t.Fatalf("err = %v, want *RemoteError of top/skip", err)
+	}
+
+	wantLines := []string{
+		"D0755 0 top",
+		"C0644 3 a",
+		"D0700 0 skip",
+		"T1500000000 0 1500000000 0",
+		"C0600 3 c",
+		"E",
+	}
+	if !reflect.DeepEqual(f.lines, wantLines) {
+		t.Errorf("lines = %q, want %q", f.lines, wantLines)
+	}
+
+	wantData := map[string]string{"a": "aaa", "c": "ccc"}
+	if !reflect.DeepEqual(f.data, wantData) {
+		t.Errorf("data = %q, want %q", f.data, wantData)
+	}
+}
+
+func TestSourceSendDataBroken(t *testing.T) {
+	data := "C0644 3 a\naa\x00\x01scp: a: read error\n" +
+		"C0644 1 b\nb\x00"
+
+	f := &fakeSink{}
+	err := runFakeSink(f, ContinueOnError, func(c *source) error {
+		return c.sendData(bytes.NewBufferString(data))
+	})
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Message != "scp: a: read error" {
+		t.Fatalf("err = %v, want relayed *RemoteError", err)
+	}
+
+	// the remote is told that a is broken
+	wantLines := []string{"C0644 3 a", "error: scplib: remote warning: scp: a: read error", "C0644 1 b"}
+	if !reflect.DeepEqual(f.lines, wantLines) {
+		t.Errorf("lines = %q, want %q", f.lines, wantLines)
+	}
+	if want := map[string]string{"b": "b"}; !reflect.DeepEqual(f.data, want) {
+		t.Errorf("data = %q, want %q", f.data, want)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"context"
+	"io"
+)
+
+// GetStream get scp format data as a stream (remote to local). The data is
+// read from remote only as fast as the returned reader is read, and the
+// reader must be closed. The error of the transfer is returned by Read.
+//
+// example:
+//
+//	stream, err := scp.GetStream(ctx, []string{"/path/remote/path"})
+//	defer stream.Close()
+func (s *SCPClient) GetStream(ctx context.Context, fromPaths []string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	scpCmd, err := s.sourceCommand(fromPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	stream := &streamReader{PipeReader: pr, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(stream.done)
+		err := s.runSink(ctx, scpCmd, &rawWriter{w: pw})
+		pw.CloseWithError(err)
+	}()
+
+	return stream, nil
+}
+
+// PutStream put scp format data read from r (local to remote). r is read
+// only as fast as the remote accept the data.
+//
+// example:
+//
+//	err := scp.PutStream(ctx, r, "/path/remote/path")
+func (s *SCPClient) PutStream(ctx context.Context, r io.Reader, toPath string) error {
+	return s.putStream(ctx, r, toPath, false)
+}
+
+// putStream is PutStream, with the -d flag of sinkCommand.
+func (s *SCPClient) putStream(ctx context.Context, r io.Reader, toPath string, targetDir bool) error {
+	scpCmd, err := s.sinkCommand(toPath, targetDir)
+	if err != nil {
+		return err
+	}
+	return s.runSource(ctx, scpCmd, func(c *source) error {
+		return c.sendData(r)
+	})
+}
+
+// streamReader is the reader returned by GetStream.
+type streamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stop the transfer, if not finished, and wait for the end of it.
+func (r *streamReader) Close() error {
+	r.cancel()
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
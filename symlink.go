@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrSymlinkSkipped is the reason of ProgressSkip events of symbolic
+	// links, not copied by the SymlinkPolicy.
+	ErrSymlinkSkipped = errors.New("scplib: symbolic link is skipped")
+
+	// ErrSymlinkLoop is returned for a followed symbolic link to its own
+	// parent directory.
+	ErrSymlinkLoop = errors.New("scplib: symbolic link loop")
+)
+
+// SymlinkPolicy decide how PutFile handle symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinkSkip do not copy symbolic links (default). The skipped links
+	// are reported as ProgressSkip events.
+	SymlinkSkip SymlinkPolicy = iota
+
+	// SymlinkFollow copy the targets of symbolic links. A link to its own
+	// parent directory is an error of ErrSymlinkLoop.
+	SymlinkFollow
+
+	// SymlinkFollowInside copy the targets of symbolic links, only if they
+	// are inside the copied tree. The tree is the argument of PutFile,
+	// after its own link is resolved. Other links are skipped.
+	SymlinkFollowInside
+)
+
+// follow return the info of the target of the symbolic link at path, or
+// nil if the link is not followed.
+func (p *pusher) follow(path, name string) (os.FileInfo, error) {
+	switch p.symlink {
+	case SymlinkFollow:
+	case SymlinkFollowInside:
+		target, err := evalSymlinks(p.fsys(), path)
+		if err != nil {
+			return nil, p.c.fail(localError("readlink", path, err))
+		}
+		if !within(p.root, target) {
+			p.skip(path, name)
+			return nil, nil
+		}
+	default:
+		p.skip(path, name)
+		return nil, nil
+	}
+
+	info, err := statFile(p.fsys(), path)
+	if err != nil {
+		return nil, p.c.fail(localError("stat", path, err))
+	}
+	if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+		// fs can not follow the link
+		p.skip(path, name)
+		return nil, nil
+	}
+	return info, nil
+}
+
+// skip report the skipped symbolic link.
+func (p *pusher) skip(path, name string) {
+	p.c.progress.skip(p.c.path(name), localError("skip", path, ErrSymlinkSkipped))
+}
+
+// within report whether path is root or inside root.
+func within(root, path string) bool {
+	if root == "" {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeLinkTree create a tree with links inside and outside of top.
+func makeLinkTree(t *testing.T) string {
+	dir := makeTree(t, map[string]string{
+		"top/a":       "aaa",
+		"top/sub/b":   "bb",
+		"outside/c":   "c",
+		"outside.txt": "o",
+	})
+
+	links := map[string]string{
+		"top/link-a":      "a",
+		"top/link-sub":    "sub",
+		"top/link-out":    "../outside.txt",
+		"top/link-outdir": "../outside",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			os.RemoveAll(dir)
+			t.Skip(err)
+		}
+	}
+	return dir
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	dir := makeLinkTree(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		policy SymlinkPolicy
+		lines  []string
+		skips  []string
+	}{
+		{
+			policy: SymlinkSkip,
+			lines: []string{
+				"D0755 0 top",
+				"C0644 3 a",
+				"D0755 0 sub",
+				"C0644 2 b",
+				"E",
+				"E",
+			},
+			skips: []string{"top/link-a", "top/link-out", "top/link-outdir", "top/link-sub"},
+		},
+		{
+			policy: SymlinkFollow,
+			lines: []string{
+				"D0755 0 top",
+				"C0644 3 a",
+				"C0644 3 link-a",
+				"C0644 1 link-out",
+				"D0755 0 link-outdir",
+				"C0644 1 c",
+				"E",
+				"D0755 0 link-sub",
+				"C0644 2 b",
+				"E",
+				"D0755 0 sub",
+				"C0644 2 b",
+				"E",
+				"E",
+			},
+		},
+		{
+			policy: SymlinkFollowInside,
+			lines: []string{
+				"D0755 0 top",
+				"C0644 3 a",
+				"C0644 3 link-a",
+				"D0755 0 link-sub",
+				"C0644 2 b",
+				"E",
+				"D0755 0 sub",
+				"C0644 2 b",
+				"E",
+				"E",
+			},
+			skips: []string{"top/link-out", "top/link-outdir"},
+		},
+	}
+
+	for _, test := range tests {
+		var skips []string
+		prog := &progress{fn: func(ev ProgressEvent) {
+			if ev.Type == ProgressSkip {
+				if !errors.Is(ev.Err, ErrSymlinkSkipped) {
+					t.Errorf("policy %d: skip error = %v", test.policy, ev.Err)
+				}
+				skips = append(skips, ev.Path)
+			}
+		}}
+
+		f := &fakeSink{}
+		err := runFakeSinkProgress(f, AbortOnError, prog, func(c *source) error {
+			p := &pusher{c: c, symlink: test.policy, root: filepath.Join(dir, "top")}
+			return p.push(filepath.Join(dir, "top"), "top")
+		})
+		if err != nil {
+			t.Fatalf("policy %d: %v", test.policy, err)
+		}
+		if !reflect.DeepEqual(f.lines, test.lines) {
+			t.Errorf("policy %d: lines = %q, want %q", test.policy, f.lines, test.lines)
+		}
+		if !reflect.DeepEqual(skips, test.skips) {
+			t.Errorf("policy %d: skips = %q, want %q", test.policy, skips, test.skips)
+		}
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	dir := makeTree(t, map[string]string{"top/sub/a": "aaa"})
+	defer os.RemoveAll(dir)
+
+	if err := os.Symlink("..", filepath.Join(dir, "top/sub/loop")); err != nil {
+		t.Skip(err)
+	}
+
+	f := &fakeSink{}
+	err := runFakeSink(f, AbortOnError, func(c *source) error {
+		p := &pusher{c: c, symlink: SymlinkFollow}
+		return p.push(filepath.Join(dir, "top"), "top")
+	})
+	if !errors.Is(err, ErrSymlinkLoop) {
+		t.Errorf("err = %v, want %v", err, ErrSymlinkLoop)
+	}
+}
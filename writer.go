@@ -0,0 +1,140 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrWriteTooLong is returned by Writer.Write, when more than Header.Size
+	// bytes are written to a file.
+	ErrWriteTooLong = errors.New("scplib: write too long")
+
+	// ErrWriteAfterClose is returned by Writer after Close.
+	ErrWriteAfterClose = errors.New("scplib: write after close")
+)
+
+// Writer write scp format data, e.g. the data for PutData or PutStream.
+// WriteHeader start a new entry, and Write write the data of a file entry.
+type Writer struct {
+	w      io.Writer
+	nb     int64 // remaining bytes of current file
+	inFile bool
+	depth  int // depth of directory
+	err    error
+}
+
+// NewWriter return Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteHeader write hdr, and prepare to accept the data of the file. The
+// time record is written before hdr, if ModTime or AccessTime is set. The
+// current file must be written to the end, before the next header.
+func (tw *Writer) WriteHeader(hdr *Header) error {
+	if err := tw.finishFile(); err != nil {
+		return err
+	}
+
+	switch hdr.Type {
+	case TypeFile, TypeDir:
+		if !validName(hdr.Name) {
+			return fmt.Errorf("scplib: invalid name %q", hdr.Name)
+		}
+		if hdr.Type == TypeFile && hdr.Size < 0 {
+			return fmt.Errorf("scplib: invalid size %d of %q", hdr.Size, hdr.Name)
+		}
+	case TypeEnd:
+		if tw.depth == 0 {
+			return errors.New("scplib: end of directory without directory")
+		}
+	default:
+		return fmt.Errorf("scplib: invalid header type %q", hdr.Type)
+	}
+
+	line := hdr.line() + "\n"
+	if hdr.hasTimes() {
+		line = hdr.timeLine() + "\n" + line
+	}
+	if _, tw.err = io.WriteString(tw.w, line); tw.err != nil {
+		return tw.err
+	}
+
+	switch hdr.Type {
+	case TypeFile:
+		tw.nb = hdr.Size
+		tw.inFile = true
+	case TypeDir:
+		tw.depth++
+	case TypeEnd:
+		tw.depth--
+	}
+	return nil
+}
+
+// Write write the data of the current file entry. ErrWriteTooLong is
+// returned, if more than Header.Size bytes are written, or if the current
+// entry is not a file.
+func (tw *Writer) Write(p []byte) (n int, err error) {
+	if tw.err != nil {
+		return 0, tw.err
+	}
+
+	tooLong := false
+	if int64(len(p)) > tw.nb {
+		p = p[:tw.nb]
+		tooLong = true
+	}
+
+	n, tw.err = tw.w.Write(p)
+	tw.nb -= int64(n)
+	if tw.err != nil {
+		return n, tw.err
+	}
+	if tooLong {
+		return n, ErrWriteTooLong
+	}
+	return n, nil
+}
+
+// finishFile write the trailing null character of the current file.
+func (tw *Writer) finishFile() error {
+	if tw.err != nil {
+		return tw.err
+	}
+	if !tw.inFile {
+		return nil
+	}
+
+	if tw.nb > 0 {
+		return fmt.Errorf("scplib: missed writing %d bytes", tw.nb)
+	}
+	tw.inFile = false
+
+	_, tw.err = tw.w.Write([]byte{respOK})
+	return tw.err
+}
+
+// Close finish the current file, and check that all directories are ended.
+// It does not close the underlying writer.
+func (tw *Writer) Close() error {
+	if tw.err == ErrWriteAfterClose {
+		return nil
+	}
+
+	if err := tw.finishFile(); err != nil {
+		return err
+	}
+	if tw.depth > 0 {
+		return fmt.Errorf("scplib: %d directories are not ended", tw.depth)
+	}
+
+	tw.err = ErrWriteAfterClose
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2019 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package scplib_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/blacknon/go-scplib"
+)
+
+func ExampleWriter() {
+	buf := new(bytes.Buffer)
+	tw := scplib.NewWriter(buf)
+
+	tw.WriteHeader(&scplib.Header{Type: scplib.TypeDir, Mode: 0755, Name: "conf"})
+	for _, name := range []string{"a.conf", "b.conf"} {
+		body := []byte("name = " + name + "\n")
+		tw.WriteHeader(&scplib.Header{Type: scplib.TypeFile, Mode: 0644, Size: int64(len(body)), Name: name})
+		tw.Write(body)
+	}
+	tw.WriteHeader(&scplib.Header{Type: scplib.TypeEnd})
+
+	if err := tw.Close(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// buf can be put with scp.PutData(buf, "/path/remote/path")
+	fmt.Printf("%q\n", buf.String())
+
+	// Output:
+	// "D0755 0 conf\nC0644 14 a.conf\nname = a.conf\n\x00C0644 14 b.conf\nname = b.conf\n\x00E\n"
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	mtime := time.Unix(1500000000, 123456000)
+	atime := time.Unix(1500000100, 0)
+
+	entries := []struct {
+		hdr  scplib.Header
+		body string
+	}{
+		{scplib.Header{Type: scplib.TypeDir, Mode: 0700, Name: "top", ModTime: mtime, AccessTime: atime}, ""},
+		{scplib.Header{Type: scplib.TypeFile, Mode: 0600, Size: 5, Name: "a b", ModTime: mtime, AccessTime: atime}, "hello"},
+		{scplib.Header{Type: scplib.TypeDir, Mode: 0755, Name: "empty"}, ""},
+		{scplib.Header{Type: scplib.TypeEnd}, ""},
+		{scplib.Header{Type: scplib.TypeFile, Mode: 0644, Size: 0, Name: "zero"}, ""},
+		{scplib.Header{Type: scplib.TypeEnd}, ""},
+	}
+
+	buf := new(bytes.Buffer)
+	tw := scplib.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := scplib.NewReader(buf)
+	for i, e := range entries {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hdr.Type != e.hdr.Type || hdr.Name != e.hdr.Name || hdr.Mode != e.hdr.Mode || hdr.Size != e.hdr.Size {
+			t.Errorf("entry %d = %+v, want %+v", i, hdr, e.hdr)
+		}
+		if !hdr.ModTime.Equal(e.hdr.ModTime) || !hdr.AccessTime.Equal(e.hdr.AccessTime) {
+			t.Errorf("entry %d: times = %v %v, want %v %v", i, hdr.ModTime, hdr.AccessTime, e.hdr.ModTime, e.hdr.AccessTime)
+		}
+		if string(body) != e.body {
+			t.Errorf("entry %d: body = %q, want %q", i, body, e.body)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("last Next = %v, want io.EOF", err)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	file := func(name string, size int64) *scplib.Header {
+		return &scplib.Header{Type: scplib.TypeFile, Mode: 0644, Size: size, Name: name}
+	}
+
+	// write too long
+	tw := scplib.NewWriter(ioutil.Discard)
+	tw.WriteHeader(file("a", 3))
+	if n, err := tw.Write([]byte("abcd")); n != 3 || err != scplib.ErrWriteTooLong {
+		t.Errorf("Write = %d, %v, want 3, ErrWriteTooLong", n, err)
+	}
+
+	// missed writing
+	tw = scplib.NewWriter(ioutil.Discard)
+	tw.WriteHeader(file("a", 3))
+	tw.Write([]byte("ab"))
+	if err := tw.WriteHeader(file("b", 0)); err == nil {
+		t.Errorf("WriteHeader after short file: no error")
+	}
+
+	// unbalanced directories
+	tw = scplib.NewWriter(ioutil.Discard)
+	if err := tw.WriteHeader(&scplib.Header{Type: scplib.TypeEnd}); err == nil {
+		t.Errorf("end without directory: no error")
+	}
+	tw.WriteHeader(&scplib.Header{Type: scplib.TypeDir, Mode: 0755, Name: "d"})
+	if err := tw.Close(); err == nil {
+		t.Errorf("Close with open directory: no error")
+	}
+
+	// invalid headers
+	for _, hdr := range []*scplib.Header{
+		file("", 0),
+		file("..", 0),
+		file("a/b", 0),
+		file("a\nb", 0),
+		file("a", -1),
+		{Type: 'X', Name: "a"},
+	} {
+		tw = scplib.NewWriter(ioutil.Discard)
+		if err := tw.WriteHeader(hdr); err == nil {
+			t.Errorf("WriteHeader(%+v): no error", hdr)
+		}
+	}
+
+	// write after close
+	tw = scplib.NewWriter(ioutil.Discard)
+	tw.Close()
+	if err := tw.WriteHeader(file("a", 0)); err != scplib.ErrWriteAfterClose {
+		t.Errorf("WriteHeader after Close = %v, want ErrWriteAfterClose", err)
+	}
+}